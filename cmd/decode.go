@@ -1,13 +1,20 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/sabouaram/data2vid/cmd/spinner"
 	"github.com/sabouaram/data2vid/internal/encoder"
+	"github.com/sabouaram/data2vid/internal/encryption"
+	"github.com/sabouaram/data2vid/internal/signature"
+	"github.com/sabouaram/data2vid/internal/types"
 
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
@@ -19,66 +26,241 @@ func init() {
 
 func DecodeCommand() *cobra.Command {
 	var (
-		outputFile, absOutput, baseName string
-		err                             error
-		enc                             *encoder.VideoEncoder
+		outputFile, absOutput string
+		passphraseFile        string
+		salvage               bool
+		force                 bool
+		damageMap             string
+		workers               int
+		identityFiles         []string
+		identities            []encryption.Identity
+		trustedValues         []string
+		trustedKeys           []signature.PublicKey
+		err                   error
+		enc                   *encoder.VideoEncoder
+		report                types.DecodeReport
 	)
 
+	// only called for encrypted videos
+	passphrase := func() ([]byte, error) {
+		return readPassphrase(passphraseFile, false)
+	}
+
 	cmd := &cobra.Command{
-		Use:   "decode [MP4 video-file]",
-		Short: "Decode a video back to its original file. Be sure to explicitly specify the file extension; otherwise, the output may be incomplete.",
+		Use:   "decode [MP4 video-file|-]",
+		Short: "Decode a video back to its original file (restored under its original name unless -o is given), archives are extracted below -o",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 
-			videoFile := args[0]
+			var (
+				videoFile = args[0]
+				opts      = types.DecodeOptions{Passphrase: passphrase}
+			)
 
-			if _, err = os.Stat(videoFile); err != nil {
-				rootLogger.Error("Video file path error",
-					zap.String("file", videoFile), zap.Error(err))
+			if videoFile != stdioPath {
+				checkVideoFile(videoFile)
+			}
 
-				os.Exit(1)
+			// no output => original file name from the manifest
+			if outputFile == stdioPath {
+				opts.Output = os.Stdout
+				absOutput = "stdout"
+			} else if outputFile != "" {
+				if absOutput, err = filepath.Abs(outputFile); err != nil {
+					rootLogger.Error("Failed to get absolute path",
+						zap.String("output", outputFile), zap.Error(err))
+
+					os.Exit(1)
+				}
+			}
+
+			// flag overrides the config file
+			if cmd.Flags().Changed("workers") {
+				rootCfg.Set("Workers", workers)
 			}
 
-			if strings.ToLower(filepath.Ext(videoFile)) != ".mp4" {
-				rootLogger.Error("Video file should be MPEG-4 with .mp4 extension",
-					zap.String("file", videoFile))
+			if enc, err = encoder.NewVideoEncoder(rootCfg); err != nil {
+				rootLogger.Error("Invalid configuration", zap.Error(err))
 
 				os.Exit(1)
 			}
 
-			if outputFile == "" {
-				baseName = filepath.Base(videoFile)
-				outputFile = strings.TrimSuffix(baseName, filepath.Ext(baseName)) + "_decoded" + filepath.Ext(baseName)
+			if identities, err = readIdentities(identityFiles); err != nil {
+				rootLogger.Error("Identity error", zap.Error(err))
+
+				os.Exit(1)
 			}
 
-			if absOutput, err = filepath.Abs(outputFile); err != nil {
-				rootLogger.Error("Failed to get absolute path",
-					zap.String("output", outputFile), zap.Error(err))
+			if trustedKeys, err = readTrustedKeys(trustedValues); err != nil {
+				rootLogger.Error("Trusted key error", zap.Error(err))
 
 				os.Exit(1)
 			}
 
-			enc = encoder.NewVideoEncoder(rootCfg)
-
 			rootLogger.Info("Starting decoding",
 				zap.String("input", videoFile),
 				zap.String("output", absOutput))
 
+			// stdin: ffmpeg needs a seekable video
+			if videoFile == stdioPath {
+				if videoFile, err = spoolStdin("data2vid-*.mp4"); err != nil {
+					rootLogger.Error("Video input error", zap.Error(err))
+
+					os.Exit(1)
+				}
+
+				defer os.Remove(videoFile)
+			}
+
+			opts.Identities, opts.TrustedKeys, opts.Salvage, opts.Force = identities, trustedKeys, salvage, force
+
 			spinner.WithLoadingSpinner(39, 100*time.Millisecond, func() {
-				if err = enc.DecodeFile(videoFile, absOutput); err != nil {
+				if report, err = enc.DecodeFile(videoFile, absOutput, opts); err != nil {
+					logSignature(report)
 					rootLogger.Error("Decoding failed", zap.Error(err))
 
+					if args[0] == stdioPath {
+						os.Remove(videoFile)
+					}
+
 					os.Exit(1)
 				}
 
 			})
 
+			logSignature(report)
+
+			if report.Damage != nil {
+				if err = writeDamageMap(report, damageMap); err != nil {
+					rootLogger.Error("Damage map error", zap.Error(err))
+
+					os.Exit(1)
+				}
+			}
+
+			if m := report.Manifest; m != nil {
+				digest, value := m.Digest()
+
+				rootLogger.Info("Manifest",
+					zap.String("name", m.Name),
+					zap.Uint64("size", m.Size),
+					zap.String("mode", m.Mode.String()),
+					zap.Time("mtime", m.ModTime),
+					zap.String("mime_type", m.MIMEType),
+					zap.String(string(digest), value))
+
+				for _, key := range slices.Sorted(maps.Keys(m.Tags)) {
+					rootLogger.Info("Tag", zap.String("key", key), zap.String("value", m.Tags[key]))
+				}
+			}
+
+			if report.TOC != nil {
+				rootLogger.Info("Successfully extracted archive",
+					zap.String("output", report.OutputPath),
+					zap.Int("entries", report.Extracted))
+
+				return
+			}
+
+			if opts.Output != nil {
+				report.OutputPath = absOutput
+			}
+
 			rootLogger.Info("Successfully decoded file",
-				zap.String("output", absOutput))
+				zap.String("output", report.OutputPath),
+				zap.Int("frames", report.Frames),
+				zap.Int("valid_frames", report.ValidFrames),
+				zap.Int("corrected_symbols", report.CorrectedSymbols),
+				zap.Int("recovered_frames", report.RecoveredFrames),
+				zap.Bool("decrypted", report.Encrypted))
 		},
 	}
 
-	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "Output file path (default: [videoname]_decoded)")
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "Output file path, - for stdout (default: original file name from the manifest)")
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "Replace an existing file at the original file name (an explicit -o path is always replaced)")
+
+	cmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "File holding the passphrase of encrypted videos on its first line (default: "+passphraseEnv+" or prompt)")
+	cmd.Flags().IntVar(&workers, "workers", 0, "Frames decoded in parallel, 0 for one per CPU (default: Workers from config.yaml)")
+	cmd.Flags().BoolVar(&salvage, "salvage", false, "Restore incomplete videos anyway: data of missing frames is zero-filled and described by a damage map")
+	cmd.Flags().StringVar(&damageMap, "damage-map", "", "JSON damage map written by --salvage (default: [output].damage.json, damage.json in archive output directories)")
+	cmd.Flags().StringArrayVarP(&identityFiles, "identity", "i", nil, "Identity file from keygen, for videos encrypted for recipients (repeatable)")
+	cmd.Flags().StringArrayVar(&trustedValues, "trusted-key", nil, "Public key from keygen --sign, or file of public keys, the video must be signed with (repeatable): unsigned, untrusted or modified videos are refused")
 
 	return cmd
 }
+
+// logSignature logs the signer of a decoded video, and warns when its signature does not match
+func logSignature(report types.DecodeReport) {
+	if report.SignatureError != nil {
+		rootLogger.Warn("Signature does not match, the video may have been modified",
+			zap.Error(report.SignatureError))
+
+		return
+	}
+
+	if report.Signer != "" {
+		rootLogger.Info("Signed video",
+			zap.String("signer", report.Signer),
+			zap.Bool("trusted", report.Trusted))
+	}
+}
+
+// checkVideoFile exits when the video argument is not an existing MP4 file
+func checkVideoFile(videoFile string) {
+	if _, err := os.Stat(videoFile); err != nil {
+		rootLogger.Error("Video file path error",
+			zap.String("file", videoFile), zap.Error(err))
+
+		os.Exit(1)
+	}
+
+	if strings.ToLower(filepath.Ext(videoFile)) != ".mp4" {
+		rootLogger.Error("Video file should be MPEG-4 with .mp4 extension",
+			zap.String("file", videoFile))
+
+		os.Exit(1)
+	}
+}
+
+// writeDamageMap logs what a salvaged decoding could not restore and writes the JSON damage map,
+// next to the output unless a path is given (not written for stdout without a path)
+func writeDamageMap(report types.DecodeReport, path string) error {
+	damage := report.Damage
+
+	rootLogger.Warn("Incomplete video salvaged",
+		zap.Int("missing_frames", len(damage.MissingFrames)),
+		zap.Int("damaged_ranges", len(damage.Ranges)),
+		zap.Uint64("size", damage.Size),
+		zap.Bool("truncated", damage.Truncated))
+
+	for _, r := range damage.Ranges {
+		rootLogger.Warn("Damaged bytes", zap.Uint64("start", r.Start), zap.Uint64("end", r.End))
+	}
+
+	for _, entry := range damage.DamagedEntries {
+		rootLogger.Warn("Damaged archive entry", zap.String("path", entry))
+	}
+
+	switch {
+	case path != "":
+	case report.OutputPath == "":
+		return nil
+	case report.TOC != nil:
+		path = filepath.Join(report.OutputPath, "damage.json")
+	default:
+		path = report.OutputPath + ".damage.json"
+	}
+
+	data, err := json.MarshalIndent(damage, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write damage map: %w", err)
+	}
+
+	rootLogger.Info("Damage map written", zap.String("path", path))
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -8,6 +9,8 @@ import (
 
 	"github.com/sabouaram/data2vid/cmd/spinner"
 	"github.com/sabouaram/data2vid/internal/encoder"
+	"github.com/sabouaram/data2vid/internal/encryption"
+	"github.com/sabouaram/data2vid/internal/signature"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
@@ -19,29 +22,74 @@ func init() {
 func EncodeCommand() *cobra.Command {
 	var (
 		outputVideo, absOutput string
+		tempDir                string
+		compression            string
+		workers                int
+		passphraseFile         string
+		encrypt                bool
+		passphrase             []byte
+		recipientValues        []string
+		recipients             []encryption.Recipient
+		signKeyFile            string
+		signKey                *signature.PrivateKey
 		err                    error
 		enc                    *encoder.VideoEncoder
+		tags                   map[string]string
 	)
 
 	cmd := &cobra.Command{
-		Use:   "encode [input-file]",
-		Short: "Encode a file into video format",
-		Args:  cobra.ExactArgs(1),
+		Use:   "encode [input-file|directory|-]...",
+		Short: "Encode a file into video format, several files or directories are encoded as an archive",
+		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			inputFile := args[0]
+			var (
+				inputFile = args[0]
+				info      os.FileInfo
+				isArchive = len(args) > 1
+			)
+
+			for _, input := range args {
+				// stdin: a single data stream
+				if input == stdioPath {
+					if len(args) > 1 {
+						rootLogger.Error("Stdin (-) cannot be encoded along with other inputs")
+
+						os.Exit(1)
+					}
+
+					continue
+				}
 
-			if _, err = os.Stat(inputFile); err != nil {
-				rootLogger.Error("Input file path error",
-					zap.String("file", inputFile), zap.Error(err))
+				if info, err = os.Stat(input); err != nil {
+					rootLogger.Error("Input file path error",
+						zap.String("file", input), zap.Error(err))
 
-				os.Exit(1)
+					os.Exit(1)
+				}
+
+				if info.IsDir() {
+					isArchive = true
+				}
 			}
 
 			if outputVideo == "" {
+				if inputFile == stdioPath {
+					rootLogger.Error("An output video (-o) is required when reading stdin")
+
+					os.Exit(1)
+				}
+
+				if inputFile, err = filepath.Abs(inputFile); err != nil {
+					rootLogger.Error("Failed to get absolute path",
+						zap.String("input", args[0]), zap.Error(err))
+
+					os.Exit(1)
+				}
+
 				baseName := filepath.Base(inputFile)
 				outputVideo = strings.TrimSuffix(baseName, filepath.Ext(baseName)) + ".mp4"
 
-			} else {
+			} else if outputVideo != stdioPath {
 				if strings.ToLower(filepath.Ext(outputVideo)) != ".mp4" {
 					outputVideo = strings.TrimSuffix(outputVideo, strings.ToLower(filepath.Ext(outputVideo))) + ".mp4"
 
@@ -50,33 +98,140 @@ func EncodeCommand() *cobra.Command {
 				}
 			}
 
-			if absOutput, err = filepath.Abs(outputVideo); err != nil {
+			if outputVideo == stdioPath {
+				absOutput = "stdout"
+			} else if absOutput, err = filepath.Abs(outputVideo); err != nil {
 				rootLogger.Error("Failed to get absolute path",
 					zap.String("output", outputVideo), zap.Error(err))
 
 				os.Exit(1)
 			}
 
-			enc = encoder.NewVideoEncoder(rootCfg)
+			// flag overrides the config file
+			if compression != "" {
+				rootCfg.Set("Compression", compression)
+			}
+
+			if cmd.Flags().Changed("workers") {
+				rootCfg.Set("Workers", workers)
+			}
+
+			if enc, err = encoder.NewVideoEncoder(rootCfg); err != nil {
+				rootLogger.Error("Invalid configuration", zap.Error(err))
+
+				os.Exit(1)
+			}
+
+			if encrypt {
+				if passphrase, err = readPassphrase(passphraseFile, true); err != nil {
+					rootLogger.Error("Passphrase error", zap.Error(err))
+
+					os.Exit(1)
+				}
+			}
+
+			if recipients, err = readRecipients(recipientValues); err != nil {
+				rootLogger.Error("Recipient error", zap.Error(err))
+
+				os.Exit(1)
+			}
+
+			if signKey, err = readSignKey(signKeyFile); err != nil {
+				rootLogger.Error("Signing key error", zap.Error(err))
+
+				os.Exit(1)
+			}
 
 			rootLogger.Info("Starting encoding",
-				zap.String("input", inputFile),
+				zap.Strings("input", args),
+				zap.Bool("archive", isArchive),
+				zap.Bool("encrypted", encrypt || len(recipients) > 0),
+				zap.Int("recipients", len(recipients)),
+				zap.Bool("signed", signKey != nil),
 				zap.String("output", absOutput))
 
+			if layout := enc.Layout(); layout.Parity > 0 {
+				rootLogger.Info("Forward error correction enabled",
+					zap.Int("parity_symbols", layout.Parity),
+					zap.Int("correctable_per_codeword", layout.Parity/2),
+					zap.String("overhead", fmt.Sprintf("%.1f%%", layout.Overhead()*100)))
+			}
+
+			if layout := enc.Layout(); layout.ParityFrames > 0 {
+				rootLogger.Info("Parity frames enabled",
+					zap.Int("group_size", layout.GroupSize),
+					zap.Int("parity_frames", layout.ParityFrames),
+					zap.String("overhead", fmt.Sprintf("%.1f%%", float64(layout.ParityFrames*100)/float64(layout.GroupSize+layout.ParityFrames))))
+			}
+
+			videoPath := absOutput
+
+			// stdout: the video is encoded into a temp file first, mp4 needs a seekable output
+			if outputVideo == stdioPath {
+				if tempDir, err = os.MkdirTemp("", "data2vid"); err != nil {
+					rootLogger.Error("Failed to create temp directory", zap.Error(err))
+
+					os.Exit(1)
+				}
+
+				defer os.RemoveAll(tempDir)
+
+				videoPath = filepath.Join(tempDir, "stdout.mp4")
+			}
+
 			spinner.WithLoadingSpinner(39, 100*time.Millisecond, func() {
-				if err = enc.EncodeFile(inputFile, absOutput); err != nil {
+				opts := encoder.EncodeOptions{Tags: tags, Passphrase: passphrase, Recipients: recipients, SignKey: signKey}
+
+				switch {
+				case inputFile == stdioPath:
+					err = enc.EncodeReader(os.Stdin, stdinName(outputVideo), videoPath, opts)
+				case isArchive:
+					err = enc.EncodeArchive(args, videoPath, opts)
+				default:
+					err = enc.EncodeFile(args[0], videoPath, opts)
+				}
+
+				if err != nil {
 					rootLogger.Error("Encoding failed", zap.Error(err))
+					os.RemoveAll(tempDir)
 
 					os.Exit(1)
 				}
 			})
 
+			if outputVideo == stdioPath {
+				if err = copyToStdout(videoPath); err != nil {
+					rootLogger.Error("Encoding failed", zap.Error(err))
+					os.RemoveAll(tempDir)
+
+					os.Exit(1)
+				}
+			}
+
 			rootLogger.Info("Successfully encoded file",
 				zap.String("output", absOutput))
 		},
 	}
 
-	cmd.Flags().StringVarP(&outputVideo, "output", "o", "", "Output video file path (default: [inputname].mp4)")
+	cmd.Flags().StringVarP(&outputVideo, "output", "o", "", "Output video file path, - for stdout (default: [inputname].mp4)")
+	cmd.Flags().StringVar(&compression, "compress", "", "Compression applied before framing: none, gzip or zstd (default: Compression from config.yaml)")
+	cmd.Flags().IntVar(&workers, "workers", 0, "Frames rendered in parallel, 0 for one per CPU (default: Workers from config.yaml)")
+	cmd.Flags().BoolVarP(&encrypt, "encrypt", "e", false, "Encrypt the video with a passphrase (prompted, or read from --passphrase-file or "+passphraseEnv+")")
+	cmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "File holding the encryption passphrase on its first line")
+	cmd.Flags().StringArrayVarP(&recipientValues, "recipient", "r", nil, "Encrypt the video for a public key from keygen, or for every public key of a file (repeatable, combinable with --encrypt)")
+	cmd.Flags().StringVar(&signKeyFile, "sign-key", "", "Signing key file from keygen --sign, the frames of the video are signed with it")
+	cmd.Flags().StringToStringVarP(&tags, "tag", "t", nil, "Tag stored in the video manifest as key=value (repeatable)")
 
 	return cmd
 }
+
+// stdinName returns the file name recorded in the manifest of stdin data: the video name
+func stdinName(outputVideo string) string {
+	if outputVideo == stdioPath {
+		return "stdin"
+	}
+
+	baseName := filepath.Base(outputVideo)
+
+	return strings.TrimSuffix(baseName, filepath.Ext(baseName))
+}
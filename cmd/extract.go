@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sabouaram/data2vid/cmd/spinner"
+	"github.com/sabouaram/data2vid/internal/encoder"
+	"github.com/sabouaram/data2vid/internal/encryption"
+	"github.com/sabouaram/data2vid/internal/types"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func init() {
+	rootCmd.AddCommand(ExtractCommand())
+}
+
+func ExtractCommand() *cobra.Command {
+	var (
+		outputDir, absOutput string
+		passphraseFile       string
+		identityFiles        []string
+		identities           []encryption.Identity
+		err                  error
+		enc                  *encoder.VideoEncoder
+		report               types.DecodeReport
+	)
+
+	// only called for encrypted videos
+	passphrase := func() ([]byte, error) {
+		return readPassphrase(passphraseFile, false)
+	}
+
+	cmd := &cobra.Command{
+		Use:   "extract [MP4 video-file] [pattern]...",
+		Short: "Extract the archive entries matching glob patterns (e.g. 'docs/*.pdf'), a directory pattern selects everything below it",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+
+			videoFile := args[0]
+
+			checkVideoFile(videoFile)
+
+			if absOutput, err = filepath.Abs(outputDir); err != nil {
+				rootLogger.Error("Failed to get absolute path",
+					zap.String("output", outputDir), zap.Error(err))
+
+				os.Exit(1)
+			}
+
+			if enc, err = encoder.NewVideoEncoder(rootCfg); err != nil {
+				rootLogger.Error("Invalid configuration", zap.Error(err))
+
+				os.Exit(1)
+			}
+
+			if identities, err = readIdentities(identityFiles); err != nil {
+				rootLogger.Error("Identity error", zap.Error(err))
+
+				os.Exit(1)
+			}
+
+			rootLogger.Info("Starting extraction",
+				zap.String("input", videoFile),
+				zap.Strings("patterns", args[1:]),
+				zap.String("output", absOutput))
+
+			spinner.WithLoadingSpinner(39, 100*time.Millisecond, func() {
+				if report, err = enc.DecodeFile(videoFile, absOutput, types.DecodeOptions{Patterns: args[1:], Passphrase: passphrase, Identities: identities}); err != nil {
+					rootLogger.Error("Extraction failed", zap.Error(err))
+
+					os.Exit(1)
+				}
+			})
+
+			if report.TOC == nil {
+				rootLogger.Info("Video is not an archive, decoded its single file",
+					zap.String("output", report.OutputPath))
+
+				return
+			}
+
+			rootLogger.Info("Successfully extracted archive entries",
+				zap.String("output", report.OutputPath),
+				zap.Int("entries", report.Extracted))
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputDir, "output", "o", ".", "Output directory")
+
+	cmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "File holding the passphrase of encrypted videos on its first line (default: "+passphraseEnv+" or prompt)")
+	cmd.Flags().StringArrayVarP(&identityFiles, "identity", "i", nil, "Identity file from keygen, for videos encrypted for recipients (repeatable)")
+
+	return cmd
+}
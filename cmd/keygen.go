@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sabouaram/data2vid/internal/encryption"
+	"github.com/sabouaram/data2vid/internal/signature"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func init() {
+	rootCmd.AddCommand(KeygenCommand())
+}
+
+func KeygenCommand() *cobra.Command {
+	var (
+		outputFile     string
+		sign           bool
+		secret, public fmt.Stringer
+		identity       encryption.Identity
+		signKey        signature.PrivateKey
+		file           *os.File
+		err            error
+	)
+
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate an identity (X25519 key pair): its public key is given to encode --recipient, the identity file to decode --identity. With --sign, generate a signing key (Ed25519) for encode --sign-key, its public key being trusted with --trusted-key",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			if sign {
+				signKey, err = signature.GenerateKey()
+				secret, public = signKey, signKey.Public()
+			} else {
+				identity, err = encryption.GenerateIdentity()
+				secret, public = identity, identity.Recipient()
+			}
+
+			if err != nil {
+				rootLogger.Error("Key generation failed", zap.Error(err))
+
+				os.Exit(1)
+			}
+
+			content := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n",
+				time.Now().Format(time.RFC3339), public, secret)
+
+			// no output => identity printed, for a secrets manager or a pipe
+			if outputFile == "" {
+				fmt.Print(content)
+
+				return
+			}
+
+			// never overwrite a key: the videos encrypted for it could no longer be decrypted
+			if file, err = os.OpenFile(outputFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600); err != nil {
+				rootLogger.Error("Failed to create key file", zap.Error(err))
+
+				os.Exit(1)
+			}
+
+			if _, err = file.WriteString(content); err == nil {
+				err = file.Close()
+			}
+
+			if err != nil {
+				rootLogger.Error("Failed to write key file", zap.Error(err))
+
+				os.Exit(1)
+			}
+
+			rootLogger.Info("Key written",
+				zap.String("file", outputFile),
+				zap.Bool("signing", sign),
+				zap.String("public_key", public.String()))
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "Key file to create, its public key is logged (default: key printed)")
+	cmd.Flags().BoolVar(&sign, "sign", false, "Generate an Ed25519 signing key instead of an X25519 identity")
+
+	return cmd
+}
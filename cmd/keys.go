@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sabouaram/data2vid/internal/encryption"
+	"github.com/sabouaram/data2vid/internal/signature"
+)
+
+// readRecipients parses the --recipient values: public keys, or files of public keys one per line
+func readRecipients(values []string) ([]encryption.Recipient, error) {
+	var recipients []encryption.Recipient
+
+	for _, value := range values {
+		if _, err := os.Stat(value); err != nil {
+			recipient, err := encryption.ParseRecipient(value)
+			if err != nil {
+				return nil, fmt.Errorf("recipient %q: %w", value, err)
+			}
+
+			recipients = append(recipients, recipient)
+
+			continue
+		}
+
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read recipients file: %w", err)
+		}
+
+		parsed, err := encryption.ParseRecipients(data)
+		if err != nil {
+			return nil, fmt.Errorf("recipients file %s: %w", value, err)
+		}
+
+		recipients = append(recipients, parsed...)
+	}
+
+	return recipients, nil
+}
+
+// readIdentities parses the --identity files written by keygen
+func readIdentities(files []string) ([]encryption.Identity, error) {
+	var identities []encryption.Identity
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identity file: %w", err)
+		}
+
+		parsed, err := encryption.ParseIdentities(data)
+		if err != nil {
+			return nil, fmt.Errorf("identity file %s: %w", file, err)
+		}
+
+		if len(parsed) == 0 {
+			return nil, fmt.Errorf("identity file %s holds no identity", file)
+		}
+
+		identities = append(identities, parsed...)
+	}
+
+	return identities, nil
+}
+
+// readSignKey parses the --sign-key file written by keygen --sign
+func readSignKey(file string) (*signature.PrivateKey, error) {
+	if file == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key file: %w", err)
+	}
+
+	key, err := signature.ParseKeyFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("signing key file %s: %w", file, err)
+	}
+
+	return &key, nil
+}
+
+// readTrustedKeys parses the --trusted-key values: public keys, or files of public keys one per line
+func readTrustedKeys(values []string) ([]signature.PublicKey, error) {
+	var keys []signature.PublicKey
+
+	for _, value := range values {
+		if _, err := os.Stat(value); err != nil {
+			key, err := signature.ParsePublicKey(value)
+			if err != nil {
+				return nil, fmt.Errorf("trusted key %q: %w", value, err)
+			}
+
+			keys = append(keys, key)
+
+			continue
+		}
+
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trusted keys file: %w", err)
+		}
+
+		parsed, err := signature.ParsePublicKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("trusted keys file %s: %w", value, err)
+		}
+
+		keys = append(keys, parsed...)
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sabouaram/data2vid/cmd/spinner"
+	"github.com/sabouaram/data2vid/internal/archive"
+	"github.com/sabouaram/data2vid/internal/encoder"
+	"github.com/sabouaram/data2vid/internal/encryption"
+	"github.com/sabouaram/data2vid/internal/types"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func init() {
+	rootCmd.AddCommand(ListCommand())
+}
+
+func ListCommand() *cobra.Command {
+	var (
+		passphraseFile string
+		identityFiles  []string
+		identities     []encryption.Identity
+		err            error
+		enc            *encoder.VideoEncoder
+		report         types.DecodeReport
+	)
+
+	// only called for encrypted videos
+	passphrase := func() ([]byte, error) {
+		return readPassphrase(passphraseFile, false)
+	}
+
+	cmd := &cobra.Command{
+		Use:   "list [MP4 video-file]",
+		Short: "List the files stored in a video, from its manifest and table of contents",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+
+			videoFile := args[0]
+
+			checkVideoFile(videoFile)
+
+			if enc, err = encoder.NewVideoEncoder(rootCfg); err != nil {
+				rootLogger.Error("Invalid configuration", zap.Error(err))
+
+				os.Exit(1)
+			}
+
+			if identities, err = readIdentities(identityFiles); err != nil {
+				rootLogger.Error("Identity error", zap.Error(err))
+
+				os.Exit(1)
+			}
+
+			spinner.WithLoadingSpinner(39, 100*time.Millisecond, func() {
+				if report, err = enc.DecodeFile(videoFile, "", types.DecodeOptions{MetadataOnly: true, Passphrase: passphrase, Identities: identities}); err != nil {
+					rootLogger.Error("Listing failed", zap.Error(err))
+
+					os.Exit(1)
+				}
+			})
+
+			if report.Manifest == nil {
+				rootLogger.Error("Video has no manifest (encoded by an older version)")
+
+				os.Exit(1)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+			if report.TOC == nil {
+				m := report.Manifest
+
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", m.Mode, m.Size, m.ModTime.Format(time.RFC3339), m.Name)
+			} else {
+				for _, entry := range report.TOC.Entries {
+					name := entry.Path
+
+					if entry.Type == archive.TypeSymlink {
+						name += " -> " + entry.Link
+					}
+
+					fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", entryMode(entry), entry.Size, entry.ModTime.Format(time.RFC3339), name)
+				}
+			}
+
+			w.Flush()
+		},
+	}
+
+	cmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "File holding the passphrase of encrypted videos on its first line (default: "+passphraseEnv+" or prompt)")
+	cmd.Flags().StringArrayVarP(&identityFiles, "identity", "i", nil, "Identity file from keygen, for videos encrypted for recipients (repeatable)")
+
+	return cmd
+}
+
+// entryMode returns the ls style mode of an archive entry
+func entryMode(entry archive.Entry) os.FileMode {
+	switch entry.Type {
+	case archive.TypeDir:
+		return entry.Mode | os.ModeDir
+	case archive.TypeSymlink:
+		return entry.Mode | os.ModeSymlink
+	}
+
+	return entry.Mode
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/sabouaram/data2vid/cmd/spinner"
+	"golang.org/x/term"
+)
+
+// environment variable holding the passphrase of encrypted videos
+const passphraseEnv = "DATA2VID_PASSPHRASE"
+
+// readPassphrase returns the passphrase from a file (its first line), the DATA2VID_PASSPHRASE
+// environment variable or a terminal prompt, typed twice when confirm is set
+func readPassphrase(file string, confirm bool) ([]byte, error) {
+	var (
+		passphrase, again []byte
+		err               error
+	)
+
+	if file != "" {
+		if passphrase, err = os.ReadFile(file); err != nil {
+			return nil, fmt.Errorf("failed to read passphrase file: %w", err)
+		}
+
+		passphrase, _, _ = bytes.Cut(passphrase, []byte("\n"))
+
+		return bytes.TrimSuffix(passphrase, []byte("\r")), nil
+	}
+
+	if env := os.Getenv(passphraseEnv); env != "" {
+		return []byte(env), nil
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("no terminal to prompt for the passphrase: use --passphrase-file or %s", passphraseEnv)
+	}
+
+	spinner.Suspend(func() {
+		fmt.Fprint(os.Stderr, "Passphrase: ")
+
+		if passphrase, err = term.ReadPassword(int(os.Stdin.Fd())); err != nil || !confirm {
+			fmt.Fprintln(os.Stderr)
+			return
+		}
+
+		fmt.Fprint(os.Stderr, "\nConfirm passphrase: ")
+		again, err = term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if confirm && !bytes.Equal(passphrase, again) {
+		return nil, errors.New("passphrases do not match")
+	}
+
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+
+	return passphrase, nil
+}
@@ -1,11 +1,17 @@
 package spinner
 
 import (
+	"sync"
 	"time"
 
 	"github.com/briandowns/spinner"
 )
 
+var (
+	activeMutex sync.Mutex
+	active      *spinner.Spinner
+)
+
 func WithLoadingSpinner(style int, delay time.Duration, action func()) {
 	var (
 		s    = spinner.New(spinner.CharSets[style], delay)
@@ -16,8 +22,10 @@ func WithLoadingSpinner(style int, delay time.Duration, action func()) {
 	s.Suffix = "   Loading"
 
 	s.Start()
+	setActive(s)
 
 	defer func(chan struct{}) {
+		setActive(nil)
 		s.Stop()
 		close(done)
 	}(done)
@@ -42,3 +50,24 @@ func WithLoadingSpinner(style int, delay time.Duration, action func()) {
 
 	action()
 }
+
+// Suspend stops the running spinner while fn runs (e.g. to prompt the user), then restarts it
+func Suspend(fn func()) {
+	activeMutex.Lock()
+	s := active
+	activeMutex.Unlock()
+
+	if s != nil {
+		s.Stop()
+		defer s.Start()
+	}
+
+	fn()
+}
+
+func setActive(s *spinner.Spinner) {
+	activeMutex.Lock()
+	defer activeMutex.Unlock()
+
+	active = s
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// file argument standing for stdin or stdout
+const stdioPath = "-"
+
+// spoolStdin copies stdin to a temp file named after pattern, for inputs ffmpeg has to seek in.
+// The caller removes the file.
+func spoolStdin(pattern string) (string, error) {
+	file, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	defer file.Close()
+
+	if _, err = io.Copy(file, os.Stdin); err != nil {
+		os.Remove(file.Name())
+
+		return "", fmt.Errorf("failed to read stdin: %w", err)
+	}
+
+	return file.Name(), nil
+}
+
+// copyToStdout writes a file to stdout
+func copyToStdout(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	if _, err = io.Copy(os.Stdout, file); err != nil {
+		return fmt.Errorf("failed to write stdout: %w", err)
+	}
+
+	return nil
+}
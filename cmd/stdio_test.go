@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// redirect replaces *std with a file for the duration of the test
+func redirect(t *testing.T, std **os.File, file *os.File) {
+	t.Helper()
+
+	saved := *std
+	*std = file
+
+	t.Cleanup(func() { *std = saved })
+}
+
+func TestStdioRoundTrip(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		data = bytes.Repeat([]byte("piped data\n"), 50000)
+	)
+
+	if err := os.WriteFile(filepath.Join(dir, "input"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdin, err := os.Open(filepath.Join(dir, "input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer stdin.Close()
+
+	stdout, err := os.Create(filepath.Join(dir, "output"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer stdout.Close()
+
+	redirect(t, &os.Stdin, stdin)
+	redirect(t, &os.Stdout, stdout)
+
+	// stdin is spooled to a seekable file, which is copied back to stdout
+	spooled, err := spoolStdin("data2vid-test-*.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(spooled)
+
+	if filepath.Ext(spooled) != ".mp4" {
+		t.Fatalf("spooled to %s", spooled)
+	}
+
+	if err = copyToStdout(spooled); err != nil {
+		t.Fatal(err)
+	}
+
+	if output, err := os.ReadFile(stdout.Name()); err != nil || !bytes.Equal(output, data) {
+		t.Fatalf("stdin not copied to stdout: %v", err)
+	}
+
+	if err = copyToStdout(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("missing file copied")
+	}
+}
+
+func TestStdinName(t *testing.T) {
+	tests := []struct {
+		outputVideo, name string
+	}{
+		{"backup.mp4", "backup"},
+		{"videos/docs.tar.mp4", "docs.tar"},
+		{"-", "stdin"},
+	}
+
+	for _, tt := range tests {
+		if name := stdinName(tt.outputVideo); name != tt.name {
+			t.Errorf("%s: got %q, expected %q", tt.outputVideo, name, tt.name)
+		}
+	}
+}
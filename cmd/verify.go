@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/sabouaram/data2vid/cmd/spinner"
+	"github.com/sabouaram/data2vid/internal/encoder"
+	"github.com/sabouaram/data2vid/internal/signature"
+	"github.com/sabouaram/data2vid/internal/types"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func init() {
+	rootCmd.AddCommand(VerifyCommand())
+}
+
+func VerifyCommand() *cobra.Command {
+	var (
+		trustedValues []string
+		trustedKeys   []signature.PublicKey
+		err           error
+		enc           *encoder.VideoEncoder
+		report        types.DecodeReport
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify [MP4 video-file]",
+		Short: "Check the signature of a video against its frames, and against trusted public keys when given (nothing is decrypted or written)",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+
+			videoFile := args[0]
+
+			checkVideoFile(videoFile)
+
+			if enc, err = encoder.NewVideoEncoder(rootCfg); err != nil {
+				rootLogger.Error("Invalid configuration", zap.Error(err))
+
+				os.Exit(1)
+			}
+
+			if trustedKeys, err = readTrustedKeys(trustedValues); err != nil {
+				rootLogger.Error("Trusted key error", zap.Error(err))
+
+				os.Exit(1)
+			}
+
+			spinner.WithLoadingSpinner(39, 100*time.Millisecond, func() {
+				if report, err = enc.DecodeFile(videoFile, "", types.DecodeOptions{VerifyOnly: true, TrustedKeys: trustedKeys}); err != nil {
+					rootLogger.Error("Verification failed", zap.Error(err))
+
+					os.Exit(1)
+				}
+			})
+
+			if report.SignatureError != nil {
+				rootLogger.Error("Verification failed", zap.Error(report.SignatureError))
+
+				os.Exit(1)
+			}
+
+			if report.Signer == "" {
+				rootLogger.Error("Verification failed: the video is not signed")
+
+				os.Exit(1)
+			}
+
+			if report.MissingFrames > 0 {
+				rootLogger.Warn("Signed frames not found in the video (lost frames may still be recovered by decode)",
+					zap.Int("missing_frames", report.MissingFrames))
+			}
+
+			if !report.Trusted {
+				rootLogger.Warn("The signer is not checked: give its public key with --trusted-key",
+					zap.String("signer", report.Signer))
+			}
+
+			rootLogger.Info("Signature verified",
+				zap.String("signer", report.Signer),
+				zap.Bool("trusted", report.Trusted),
+				zap.Int("valid_frames", report.ValidFrames))
+		},
+	}
+
+	cmd.Flags().StringArrayVar(&trustedValues, "trusted-key", nil, "Public key from keygen --sign, or file of public keys, the video must be signed with (repeatable)")
+
+	return cmd
+}
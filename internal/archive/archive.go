@@ -0,0 +1,379 @@
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MIMEType is the manifest MIME type of archive videos
+const MIMEType = "application/x-data2vid-archive"
+
+// EntryType identifies the kind of file system object of an archive entry
+type EntryType string
+
+const (
+	TypeFile    EntryType = "file"
+	TypeDir     EntryType = "dir"
+	TypeSymlink EntryType = "symlink"
+)
+
+// Entry describes one file, directory or symlink of an archive. The contents of the regular
+// files are concatenated in entry order in the data frames, at Offset for Size bytes.
+type Entry struct {
+	Path    string      `json:"path"` // relative, slash separated
+	Type    EntryType   `json:"type"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	Size    uint64      `json:"size,omitempty"`
+	Offset  uint64      `json:"offset,omitempty"`
+	Link    string      `json:"link,omitempty"`
+
+	// file read on encode
+	source string
+}
+
+// TOC is the table of contents stored as JSON in the table of contents frames
+type TOC struct {
+	Entries []Entry `json:"entries"`
+}
+
+// Collect walks the input files and directories and returns their entries: a directory is
+// stored under its base name with everything below it, symlinks are stored, not followed
+func Collect(inputs []string) (TOC, error) {
+	var (
+		toc    TOC
+		offset uint64
+		seen   = make(map[string]bool)
+	)
+
+	for _, input := range inputs {
+		var (
+			root = filepath.Clean(input)
+			base string
+		)
+
+		// "." or "/" => contents stored at the top of the archive
+		if abs, err := filepath.Abs(root); err == nil && abs != "/" && root != "." {
+			base = filepath.Base(abs)
+		}
+
+		err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+			var (
+				info os.FileInfo
+				rel  string
+			)
+
+			if err != nil {
+				return err
+			}
+
+			if rel, err = filepath.Rel(root, name); err != nil {
+				return err
+			}
+
+			entry := Entry{Path: path.Join(base, filepath.ToSlash(rel)), source: name}
+
+			if entry.Path == "." {
+				return nil
+			}
+
+			if seen[entry.Path] {
+				return fmt.Errorf("duplicate archive path %q", entry.Path)
+			}
+
+			seen[entry.Path] = true
+
+			if info, err = d.Info(); err != nil {
+				return err
+			}
+
+			entry.Mode = info.Mode().Perm()
+			entry.ModTime = info.ModTime().UTC()
+
+			switch {
+			case d.Type()&fs.ModeSymlink != 0:
+				entry.Type = TypeSymlink
+
+				if entry.Link, err = os.Readlink(name); err != nil {
+					return err
+				}
+
+			case d.IsDir():
+				entry.Type = TypeDir
+
+			case d.Type().IsRegular():
+				entry.Type = TypeFile
+				entry.Size = uint64(info.Size())
+				entry.Offset = offset
+				offset += entry.Size
+
+			default:
+				// devices, sockets and pipes have no data to store
+				return nil
+			}
+
+			toc.Entries = append(toc.Entries, entry)
+
+			return nil
+		})
+
+		if err != nil {
+			return toc, fmt.Errorf("failed to collect %s: %w", input, err)
+		}
+	}
+
+	return toc, nil
+}
+
+// Size returns the length of the archive data: the sum of the regular file sizes
+func (t TOC) Size() uint64 {
+	var size uint64
+
+	for _, entry := range t.Entries {
+		size += entry.Size
+	}
+
+	return size
+}
+
+// Marshal encodes the table of contents into its frame payload
+func (t TOC) Marshal() ([]byte, error) {
+	return json.Marshal(t)
+}
+
+// Parse decodes a table of contents frame payload
+func Parse(data []byte) (TOC, error) {
+	var t TOC
+
+	if err := json.Unmarshal(data, &t); err != nil {
+		return t, fmt.Errorf("invalid table of contents: %w", err)
+	}
+
+	return t, nil
+}
+
+// Select returns the entries matching any of the glob patterns (path.Match syntax), along with
+// everything below a matching directory. No pattern selects every entry.
+func (t TOC) Select(patterns []string) ([]Entry, error) {
+	var selected []Entry
+
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	for _, entry := range t.Entries {
+		if len(patterns) == 0 || matches(entry.Path, patterns) {
+			selected = append(selected, entry)
+		}
+	}
+
+	return selected, nil
+}
+
+// matches reports whether a path or one of its parent directories matches a pattern
+func matches(name string, patterns []string) bool {
+	for ; name != "." && name != "/"; name = path.Dir(name) {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Reader returns the archive data: the regular file contents in entry order. Files are opened
+// one at a time and must still have the size recorded by Collect.
+func (t TOC) Reader() io.Reader {
+	return &dataReader{entries: t.Entries}
+}
+
+type dataReader struct {
+	entries []Entry
+	current *os.File
+	left    int64
+}
+
+func (r *dataReader) Read(p []byte) (int, error) {
+	for r.current == nil {
+		if len(r.entries) == 0 {
+			return 0, io.EOF
+		}
+
+		entry := r.entries[0]
+		r.entries = r.entries[1:]
+
+		if entry.Type != TypeFile {
+			continue
+		}
+
+		file, err := os.Open(entry.source)
+		if err != nil {
+			return 0, err
+		}
+
+		r.current, r.left = file, int64(entry.Size)
+	}
+
+	n, err := r.current.Read(p[:min(int64(len(p)), r.left)])
+	r.left -= int64(n)
+
+	if err == io.EOF && r.left > 0 {
+		err = fmt.Errorf("%s shrank while being encoded", r.current.Name())
+	}
+
+	if r.left == 0 {
+		r.current.Close()
+		r.current, err = nil, nil
+	}
+
+	return n, err
+}
+
+// Extract writes the given entries below dir, file contents being read from the archive data.
+// Entry paths are checked so that nothing is written outside dir, including through symlinks
+// created by earlier entries.
+func Extract(entries []Entry, data io.ReaderAt, dir string) error {
+	var dirs []Entry
+
+	for _, entry := range entries {
+		target, err := securePath(dir, entry.Path)
+		if err != nil {
+			return err
+		}
+
+		if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+
+		switch entry.Type {
+		case TypeDir:
+			// an earlier symlink entry must not redirect the directory mode and times
+			if info, lstatErr := os.Lstat(target); lstatErr == nil && info.Mode()&fs.ModeSymlink != 0 {
+				return fmt.Errorf("unsafe archive path %q: %s is a symlink", entry.Path, target)
+			}
+
+			if err = os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+
+			// permissions and times once the directory content is written
+			dirs = append(dirs, entry)
+
+		case TypeSymlink:
+			os.Remove(target)
+
+			if err = os.Symlink(entry.Link, target); err != nil {
+				return fmt.Errorf("failed to create symlink: %w", err)
+			}
+
+		case TypeFile:
+			if err = extractFile(entry, data, target); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("unsupported entry type %q for %s", entry.Type, entry.Path)
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		target, err := securePath(dir, dirs[i].Path)
+		if err != nil {
+			return err
+		}
+
+		// replaced by a later entry
+		if info, err := os.Lstat(target); err != nil || !info.IsDir() {
+			continue
+		}
+
+		if err := os.Chmod(target, dirs[i].Mode.Perm()); err != nil {
+			return fmt.Errorf("failed to restore mode: %w", err)
+		}
+
+		if err := os.Chtimes(target, dirs[i].ModTime, dirs[i].ModTime); err != nil {
+			return fmt.Errorf("failed to restore modification time: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// extractFile writes a regular file entry with its mode and modification time
+func extractFile(entry Entry, data io.ReaderAt, target string) error {
+	var (
+		file *os.File
+		err  error
+	)
+
+	// never write through an existing symlink
+	os.Remove(target)
+
+	if file, err = os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600); err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+
+	defer file.Close()
+
+	if _, err = io.Copy(file, io.NewSectionReader(data, int64(entry.Offset), int64(entry.Size))); err != nil {
+		return fmt.Errorf("failed to write %s: %w", entry.Path, err)
+	}
+
+	if err = file.Chmod(entry.Mode.Perm()); err != nil {
+		return fmt.Errorf("failed to restore mode: %w", err)
+	}
+
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", entry.Path, err)
+	}
+
+	if err = os.Chtimes(target, entry.ModTime, entry.ModTime); err != nil {
+		return fmt.Errorf("failed to restore modification time: %w", err)
+	}
+
+	return nil
+}
+
+// securePath returns the extraction path of an entry, rejecting absolute paths, paths leaving
+// dir and paths going through a symlink
+func securePath(dir, name string) (string, error) {
+	clean := path.Clean(name)
+
+	if name == "" || path.IsAbs(name) || clean == ".." || strings.HasPrefix(clean, "../") || strings.Contains(name, `\`) {
+		return "", fmt.Errorf("unsafe archive path %q", name)
+	}
+
+	parts := strings.Split(clean, "/")
+	current := dir
+
+	for _, part := range parts[:len(parts)-1] {
+		current = filepath.Join(current, part)
+
+		info, err := os.Lstat(current)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return "", fmt.Errorf("unsafe archive path %q: %s is a symlink", name, current)
+		}
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
@@ -0,0 +1,170 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectExtractRoundTrip(t *testing.T) {
+	var (
+		src     = t.TempDir()
+		dst     = t.TempDir()
+		modTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	)
+
+	for name, content := range map[string]string{"tree/a.txt": "aaa", "tree/sub/b.pdf": "bbbbb", "tree/sub/deep/empty": "", "single": "s"} {
+		if err := os.MkdirAll(filepath.Join(src, filepath.Dir(name)), 0750); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Symlink("../a.txt", filepath.Join(src, "tree/sub/link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"tree/sub/b.pdf", "tree/sub"} {
+		if err := os.Chtimes(filepath.Join(src, name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	toc, err := Collect([]string{filepath.Join(src, "tree"), filepath.Join(src, "single")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(toc.Reader())
+	if err != nil || uint64(len(data)) != toc.Size() {
+		t.Fatalf("%d bytes of data for %d (%v)", len(data), toc.Size(), err)
+	}
+
+	marshalled, err := toc.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if toc, err = Parse(marshalled); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Extract(toc.Entries, bytes.NewReader(data), dst); err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(dst, "tree/sub/b.pdf"))
+	file, _ := os.Stat(filepath.Join(dst, "tree/sub/b.pdf"))
+	dir, _ := os.Stat(filepath.Join(dst, "tree/sub"))
+	link, _ := os.Readlink(filepath.Join(dst, "tree/sub/link"))
+	single, _ := os.ReadFile(filepath.Join(dst, "single"))
+
+	switch {
+	case string(content) != "bbbbb" || string(single) != "s":
+		t.Fatal("file contents not restored")
+	case file.Mode().Perm() != 0640 || dir.Mode().Perm() != 0750:
+		t.Fatalf("modes %v and %v not restored", file.Mode(), dir.Mode())
+	case !file.ModTime().Equal(modTime) || !dir.ModTime().Equal(modTime):
+		t.Fatal("modification times not restored")
+	case link != "../a.txt":
+		t.Fatalf("symlink to %q", link)
+	}
+}
+
+func TestExtractRejectsUnsafePaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []Entry
+	}{
+		{"parent directory", []Entry{{Path: "../x", Type: TypeFile}}},
+		{"parent directory after cleaning", []Entry{{Path: "a/../../x", Type: TypeFile}}},
+		{"absolute path", []Entry{{Path: "/x", Type: TypeFile}}},
+		{"backslash separators", []Entry{{Path: `..\x`, Type: TypeFile}}},
+		{"empty path", []Entry{{Path: "", Type: TypeFile}}},
+		{"file through an extracted symlink", []Entry{
+			{Path: "link", Type: TypeSymlink, Link: "../outside"},
+			{Path: "link/x", Type: TypeFile},
+		}},
+		{"file through a symlink to the parent", []Entry{
+			{Path: "up", Type: TypeSymlink, Link: ".."},
+			{Path: "up/outside/x", Type: TypeFile},
+		}},
+		{"directory through an extracted symlink", []Entry{
+			{Path: "link", Type: TypeSymlink, Link: "../outside"},
+			{Path: "link", Type: TypeDir, Mode: 0777},
+		}},
+		{"symlink through an extracted symlink", []Entry{
+			{Path: "link", Type: TypeSymlink, Link: "../outside"},
+			{Path: "link/x", Type: TypeSymlink, Link: "/"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				root    = t.TempDir()
+				dir     = filepath.Join(root, "dir")
+				outside = filepath.Join(root, "outside")
+			)
+
+			for _, d := range []string{dir, outside} {
+				if err := os.Mkdir(d, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := Extract(tt.entries, bytes.NewReader(nil), dir)
+			if err == nil || !strings.Contains(err.Error(), "unsafe archive path") {
+				t.Fatalf("got %v, expected an unsafe archive path", err)
+			}
+
+			if written, _ := os.ReadDir(outside); len(written) > 0 {
+				t.Fatalf("%s written outside the extraction directory", written[0].Name())
+			}
+
+			if info, err := os.Stat(outside); err != nil || info.Mode().Perm() != 0755 {
+				t.Fatal("directory outside the extraction directory modified")
+			}
+		})
+	}
+}
+
+// a file entry replaces an extracted symlink instead of writing to its target
+func TestExtractReplacesSymlink(t *testing.T) {
+	var (
+		root    = t.TempDir()
+		dir     = filepath.Join(root, "dir")
+		outside = filepath.Join(root, "outside")
+		entries = []Entry{
+			{Path: "name", Type: TypeSymlink, Link: "../outside"},
+			{Path: "name", Type: TypeFile, Mode: 0644, Size: 4},
+		}
+	)
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(outside, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Extract(entries, strings.NewReader("data"), dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if kept, _ := os.ReadFile(outside); string(kept) != "keep" {
+		t.Fatal("symlink target overwritten")
+	}
+
+	if info, err := os.Lstat(filepath.Join(dir, "name")); err != nil || !info.Mode().IsRegular() {
+		t.Fatal("symlink not replaced by the file")
+	}
+}
@@ -0,0 +1,76 @@
+package checksum
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/crc64"
+	"strings"
+)
+
+// Algorithm identifies the checksum of the frame payloads, it is recorded in every frame header
+type Algorithm uint8
+
+const (
+	// LegacyCRC64 is the MSB first CRC-64 (ECMA-182 polynomial) of the legacy YTDSv3 frames, their
+	// headers being protected by ComputeChecksum
+	LegacyCRC64 Algorithm = iota
+
+	// CRC32C is the Castagnoli CRC-32, hardware accelerated on most CPUs
+	CRC32C
+
+	// CRC64 is the CRC-64 of hash/crc64 with the ECMA-182 polynomial (as in xz)
+	CRC64
+)
+
+var (
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+	ecma       = crc64.MakeTable(crc64.ECMA)
+)
+
+// ParseAlgorithm converts a config value ("crc32c" or "crc64") to an Algorithm, CRC-64 by default
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch strings.ToLower(name) {
+	case "", "crc64":
+		return CRC64, nil
+	case "crc32c":
+		return CRC32C, nil
+	}
+
+	return CRC64, fmt.Errorf("unsupported checksum %q (expected crc32c or crc64)", name)
+}
+
+// Valid reports whether the algorithm is known
+func (a Algorithm) Valid() bool {
+	return a <= CRC64
+}
+
+func (a Algorithm) String() string {
+	switch a {
+	case LegacyCRC64:
+		return "crc64-legacy"
+	case CRC32C:
+		return "crc32c"
+	case CRC64:
+		return "crc64"
+	}
+
+	return fmt.Sprintf("unknown(%d)", uint8(a))
+}
+
+// Sum returns the checksum of a frame payload, CRC-32C in the low 32 bits
+func (a Algorithm) Sum(data []byte) uint64 {
+	switch a {
+	case CRC32C:
+		return uint64(crc32.Checksum(data, castagnoli))
+	case CRC64:
+		return crc64.Checksum(data, ecma)
+	}
+
+	return legacyCRC64(data)
+}
+
+// HeaderSum returns the 4 bytes checksum of a frame header, its CRC-32C
+func HeaderSum(data []byte) []byte {
+	return binary.BigEndian.AppendUint32(nil, crc32.Checksum(data, castagnoli))
+}
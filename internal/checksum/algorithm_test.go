@@ -0,0 +1,57 @@
+package checksum
+
+import (
+	"bytes"
+	"testing"
+)
+
+// check is the standard input of CRC catalogues
+var check = []byte("123456789")
+
+func TestSum(t *testing.T) {
+	tests := []struct {
+		algorithm Algorithm
+		expected  uint64
+	}{
+		{CRC32C, 0xE3069283},
+		{CRC64, 0x995DC9BBDF1939FA},
+		{LegacyCRC64, 0x62EC59E3F1A4F00A},
+	}
+
+	for _, tt := range tests {
+		if sum := tt.algorithm.Sum(check); sum != tt.expected {
+			t.Errorf("%s: got %#x, expected %#x", tt.algorithm, sum, tt.expected)
+		}
+	}
+}
+
+func TestHeaderSum(t *testing.T) {
+	if sum := HeaderSum(check); !bytes.Equal(sum, []byte{0xE3, 0x06, 0x92, 0x83}) {
+		t.Fatalf("got %x", sum)
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm Algorithm
+		valid     bool
+	}{
+		{"", CRC64, true},
+		{"crc64", CRC64, true},
+		{"CRC32C", CRC32C, true},
+		{"crc64-legacy", CRC64, false},
+		{"md5", CRC64, false},
+	}
+
+	for _, tt := range tests {
+		algorithm, err := ParseAlgorithm(tt.name)
+		if algorithm != tt.algorithm || (err == nil) != tt.valid {
+			t.Errorf("%q: got %s (%v)", tt.name, algorithm, err)
+		}
+	}
+
+	if Algorithm(3).Valid() || !LegacyCRC64.Valid() {
+		t.Fatal("unexpected valid algorithms")
+	}
+}
@@ -10,20 +10,31 @@ func ComputeChecksum(data []byte) []byte {
 	return sum
 }
 
-func CRC64(data []byte) uint64 {
+// MSB first CRC-64 with the ECMA-182 polynomial, one table lookup per byte
+var crc64Table = func() (table [256]uint64) {
+	for i := range table {
+		crc := uint64(i) << 56
 
-	var crc uint64 = 0xFFFFFFFFFFFFFFFF
-
-	for _, b := range data {
-		crc ^= uint64(b) << 56
-
-		for i := 0; i < 8; i++ {
+		for j := 0; j < 8; j++ {
 			if crc&(1<<63) != 0 {
 				crc = (crc << 1) ^ 0x42F0E1EBA9EA3693
 			} else {
 				crc <<= 1
 			}
 		}
+
+		table[i] = crc
+	}
+
+	return table
+}()
+
+func legacyCRC64(data []byte) uint64 {
+
+	var crc uint64 = 0xFFFFFFFFFFFFFFFF
+
+	for _, b := range data {
+		crc = (crc << 8) ^ crc64Table[byte(crc>>56)^b]
 	}
 
 	return crc ^ 0xFFFFFFFFFFFFFFFF
@@ -0,0 +1,49 @@
+package checksum
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"testing"
+)
+
+// bitwiseCRC64 is the bit by bit CRC-64 the legacy table replaced
+func bitwiseCRC64(data []byte) uint64 {
+	var crc uint64 = 0xFFFFFFFFFFFFFFFF
+
+	for _, b := range data {
+		crc ^= uint64(b) << 56
+
+		for i := 0; i < 8; i++ {
+			if crc&(1<<63) != 0 {
+				crc = (crc << 1) ^ 0x42F0E1EBA9EA3693
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc ^ 0xFFFFFFFFFFFFFFFF
+}
+
+func TestLegacyCRC64(t *testing.T) {
+	var (
+		random = rand.New(rand.NewChaCha8([32]byte{}))
+		data   = make([]byte, 4096)
+	)
+
+	for i := range data {
+		data[i] = byte(random.Uint32())
+	}
+
+	for _, size := range []int{0, 1, 7, 64, 4096} {
+		if sum, expected := legacyCRC64(data[:size]), bitwiseCRC64(data[:size]); sum != expected {
+			t.Errorf("%d bytes: got %#x, expected %#x", size, sum, expected)
+		}
+	}
+}
+
+func TestComputeChecksum(t *testing.T) {
+	if sum := ComputeChecksum(check); !bytes.Equal(sum, []byte{'1' ^ '5' ^ '9', '2' ^ '6', '3' ^ '7', '4' ^ '8'}) {
+		t.Fatalf("got %x", sum)
+	}
+}
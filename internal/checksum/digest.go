@@ -0,0 +1,51 @@
+package checksum
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Digest identifies the hash of whole files recorded in the manifest
+type Digest string
+
+const (
+	SHA256  Digest = "sha256"
+	BLAKE2b Digest = "blake2b"
+)
+
+// ParseDigest converts a config value to a Digest, SHA-256 by default
+func ParseDigest(name string) (Digest, error) {
+	switch Digest(strings.ToLower(name)) {
+	case "", SHA256:
+		return SHA256, nil
+	case BLAKE2b:
+		return BLAKE2b, nil
+	}
+
+	return "", fmt.Errorf("unsupported digest %q (expected %s or %s)", name, SHA256, BLAKE2b)
+}
+
+// New returns a hash computing the digest: SHA-256 or BLAKE2b-256
+func (d Digest) New() hash.Hash {
+	if d == BLAKE2b {
+		// only fails for keys longer than 64 bytes
+		h, _ := blake2b.New256(nil)
+
+		return h
+	}
+
+	return sha256.New()
+}
+
+// Title returns the name of the digest in messages
+func (d Digest) Title() string {
+	if d == BLAKE2b {
+		return "BLAKE2b-256"
+	}
+
+	return "SHA-256"
+}
@@ -0,0 +1,36 @@
+package checksum
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestDigest(t *testing.T) {
+	tests := []struct {
+		name     string
+		digest   Digest
+		expected string
+	}{
+		{"", SHA256, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"SHA256", SHA256, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"blake2b", BLAKE2b, "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"},
+	}
+
+	for _, tt := range tests {
+		digest, err := ParseDigest(tt.name)
+		if err != nil || digest != tt.digest {
+			t.Fatalf("%q: got %s (%v)", tt.name, digest, err)
+		}
+
+		h := digest.New()
+		h.Write([]byte("abc"))
+
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != tt.expected {
+			t.Errorf("%s: got %s, expected %s", digest, sum, tt.expected)
+		}
+	}
+
+	if _, err := ParseDigest("md5"); err == nil {
+		t.Fatal("unknown digest accepted")
+	}
+}
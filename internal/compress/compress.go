@@ -0,0 +1,98 @@
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Algorithm identifies the compression applied to the data stream before framing,
+// it is recorded in every data frame header
+type Algorithm uint8
+
+const (
+	// None stores the data as is
+	None Algorithm = iota
+
+	// Gzip compresses the data with the standard library gzip (deflate) implementation
+	Gzip
+
+	// Zstd compresses the data with Zstandard
+	Zstd
+)
+
+// ParseAlgorithm converts a config value ("none", "gzip" or "zstd") to an Algorithm
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return None, nil
+	case "gzip":
+		return Gzip, nil
+	case "zstd":
+		return Zstd, nil
+	}
+
+	return None, fmt.Errorf("unsupported compression %q (expected none, gzip or zstd)", name)
+}
+
+// Valid reports whether the algorithm is known
+func (a Algorithm) Valid() bool {
+	return a <= Zstd
+}
+
+func (a Algorithm) String() string {
+	switch a {
+	case None:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	}
+
+	return fmt.Sprintf("unknown(%d)", uint8(a))
+}
+
+// NewWriter returns a writer compressing into w, it must be closed to flush the stream
+func (a Algorithm) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	switch a {
+	case None:
+		return nopWriteCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	case Zstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	}
+
+	return nil, fmt.Errorf("unsupported compression %s", a)
+}
+
+// NewReader returns a reader decompressing r
+func (a Algorithm) NewReader(r io.Reader) (io.ReadCloser, error) {
+	switch a {
+	case None:
+		return io.NopCloser(r), nil
+	case Gzip:
+		return gzip.NewReader(r)
+	case Zstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return decoder.IOReadCloser(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported compression %s", a)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,119 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"math/rand/v2"
+	"testing"
+)
+
+// roundTrip compresses data with the algorithm and decompresses it back
+func roundTrip(t *testing.T, a Algorithm, data []byte) (compressed, decompressed []byte) {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	writer, err := a.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	compressed = bytes.Clone(buf.Bytes())
+
+	reader, err := a.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer reader.Close()
+
+	if decompressed, err = io.ReadAll(reader); err != nil {
+		t.Fatal(err)
+	}
+
+	return compressed, decompressed
+}
+
+func TestRoundTrip(t *testing.T) {
+	var (
+		text   = bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 2000)
+		random = make([]byte, 64<<10)
+	)
+
+	rand.NewChaCha8([32]byte{}).Read(random)
+
+	for _, a := range []Algorithm{None, Gzip, Zstd} {
+		t.Run(a.String(), func(t *testing.T) {
+			compressed, decompressed := roundTrip(t, a, text)
+			if !bytes.Equal(decompressed, text) {
+				t.Fatal("text not restored")
+			}
+
+			if a != None && len(compressed) >= len(text)/10 {
+				t.Fatalf("text compressed to %d bytes", len(compressed))
+			}
+
+			// incompressible data grows a little but is restored
+			if _, decompressed = roundTrip(t, a, random); !bytes.Equal(decompressed, random) {
+				t.Fatal("random data not restored")
+			}
+
+			if _, decompressed = roundTrip(t, a, nil); len(decompressed) != 0 {
+				t.Fatal("empty stream not restored")
+			}
+		})
+	}
+}
+
+func TestCorruptedStream(t *testing.T) {
+	data := bytes.Repeat([]byte("some data "), 1000)
+
+	for _, a := range []Algorithm{Gzip, Zstd} {
+		compressed, _ := roundTrip(t, a, data)
+		compressed[len(compressed)/2] ^= 0xff
+
+		reader, err := a.NewReader(bytes.NewReader(compressed))
+		if err == nil {
+			_, err = io.ReadAll(reader)
+			reader.Close()
+		}
+
+		if err == nil {
+			t.Errorf("%s: corrupted stream decompressed", a)
+		}
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm Algorithm
+		valid     bool
+	}{
+		{"", None, true},
+		{"none", None, true},
+		{"GZIP", Gzip, true},
+		{"zstd", Zstd, true},
+		{"brotli", None, false},
+	}
+
+	for _, tt := range tests {
+		algorithm, err := ParseAlgorithm(tt.name)
+
+		if (err == nil) != tt.valid || algorithm != tt.algorithm {
+			t.Errorf("%q: got %v (%v)", tt.name, algorithm, err)
+		}
+	}
+
+	if Algorithm(3).Valid() {
+		t.Error("unknown algorithm valid")
+	}
+}
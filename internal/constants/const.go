@@ -1,7 +1,5 @@
 package constants
 
-var MaxPayloadPerFrame = ((DefaultHeight * DefaultWidth) / 8) - HeaderSize
-
 const (
 	// default frame width
 	DefaultWidth = 1280
@@ -12,9 +10,43 @@ const (
 	// default frame rate
 	DefaultFrameRate = 1
 
+	// default payload bits carried by each pixel (1 => black & white)
+	DefaultBitsPerPixel = 1
+
+	// default block size: each symbol covers BlockSize x BlockSize pixels
+	DefaultBlockSize = 1
+
+	// largest block size probed by the decoder
+	MaxBlockSize = 32
+
+	// default number of data frames protected by each set of parity frames
+	DefaultParityGroupSize = 10
+
 	// encoding identifier
-	MagicString = "YTDSv3" // 6 byte magic string
+	MagicString = "YTDSv4" // 6 byte magic string
+
+	// frame format version
+	FormatVersion = 4
+
+	// header copies written in every frame: top, middle and bottom
+	HeaderCopies = 3
+
+	// gray levels of the calibration patch written after every header copy, from white to black
+	CalibrationLevels = 16
+
+	// cells painted with each calibration level
+	CalibrationCellsPerLevel = 4
+
+	// cells on each side of the corner squares holding the finder patterns: a 7 cells pattern
+	// within a white border of one cell
+	FinderSize = 9
 
 	// frame header size
-	HeaderSize = 32
+	HeaderSize = 64
+
+	// legacy encoding identifier, still decoded
+	LegacyMagicString = "YTDSv3"
+
+	// legacy frame header size
+	LegacyHeaderSize = 32
 )
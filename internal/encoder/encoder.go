@@ -2,161 +2,517 @@ package encoder
 
 import (
 	"fmt"
+	"image"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 
+	"github.com/sabouaram/data2vid/internal/archive"
+	"github.com/sabouaram/data2vid/internal/checksum"
+	"github.com/sabouaram/data2vid/internal/compress"
 	"github.com/sabouaram/data2vid/internal/constants"
+	"github.com/sabouaram/data2vid/internal/encryption"
 	"github.com/sabouaram/data2vid/internal/frame"
+	"github.com/sabouaram/data2vid/internal/manifest"
+	"github.com/sabouaram/data2vid/internal/signature"
+	"github.com/sabouaram/data2vid/internal/types"
 	"github.com/sabouaram/data2vid/internal/video"
 	"github.com/spf13/viper"
 )
 
 // VideoEncoder handles encoding and decoding of files to/from video
 type VideoEncoder struct {
-	frameWidth  int
-	frameHeight int
+	layout      frame.Layout
+	compression compress.Algorithm
+	checksum    checksum.Algorithm
+	digest      checksum.Digest
+	cipher      encryption.Cipher
 	frameRate   int
-	tempDir     string
+	workers     int
 	mutex       sync.Mutex
 }
 
-// NewVideoEncoder creates a new encoder with default constant settings
-func NewVideoEncoder(cfg *viper.Viper) *VideoEncoder {
+// opener returns a new reader over the data to encode, it is called once per pass over the data
+type opener func() (io.ReadCloser, error)
+
+// NewVideoEncoder creates a new encoder with default constant settings overridden by the config
+func NewVideoEncoder(cfg *viper.Viper) (*VideoEncoder, error) {
+	var err error
+
 	encoder := &VideoEncoder{
-		frameWidth:  constants.DefaultWidth,
-		frameHeight: constants.DefaultHeight,
-		frameRate:   constants.DefaultFrameRate,
+		layout: frame.Layout{
+			Width:        constants.DefaultWidth,
+			Height:       constants.DefaultHeight,
+			BitsPerPixel: constants.DefaultBitsPerPixel,
+			BlockSize:    constants.DefaultBlockSize,
+		},
+		frameRate: constants.DefaultFrameRate,
+		checksum:  checksum.CRC64,
+		digest:    checksum.SHA256,
+		cipher:    encryption.ChaCha20Poly1305,
 	}
 
 	if cfg != nil {
 		if cfg.GetInt("Width") != 0 {
-			encoder.frameWidth = cfg.GetInt("Width")
+			encoder.layout.Width = cfg.GetInt("Width")
 		}
 
 		if cfg.GetInt("Height") != 0 {
-			encoder.frameHeight = cfg.GetInt("Height")
+			encoder.layout.Height = cfg.GetInt("Height")
+		}
+
+		if cfg.GetInt("BitsPerPixel") != 0 {
+			encoder.layout.BitsPerPixel = cfg.GetInt("BitsPerPixel")
+		}
+
+		if cfg.GetInt("BlockSize") != 0 {
+			encoder.layout.BlockSize = cfg.GetInt("BlockSize")
+		}
+
+		if cfg.GetInt("FECParity") != 0 {
+			encoder.layout.Parity = cfg.GetInt("FECParity")
+		}
+
+		if cfg.GetInt("ParityFrames") != 0 {
+			encoder.layout.ParityFrames = cfg.GetInt("ParityFrames")
+			encoder.layout.GroupSize = constants.DefaultParityGroupSize
+		}
+
+		if cfg.GetInt("ParityGroupSize") != 0 {
+			encoder.layout.GroupSize = cfg.GetInt("ParityGroupSize")
+		}
+
+		if encoder.layout.ColorMode, err = frame.ParseColorMode(cfg.GetString("ColorMode")); err != nil {
+			return nil, err
+		}
+
+		// frame rendering and decoding workers, 0 => GOMAXPROCS
+		encoder.workers = cfg.GetInt("Workers")
+
+		if encoder.compression, err = compress.ParseAlgorithm(cfg.GetString("Compression")); err != nil {
+			return nil, err
+		}
+
+		if encoder.cipher, err = encryption.ParseCipher(cfg.GetString("Cipher")); err != nil {
+			return nil, err
+		}
+
+		if encoder.checksum, err = checksum.ParseAlgorithm(cfg.GetString("Checksum")); err != nil {
+			return nil, err
+		}
+
+		if encoder.digest, err = checksum.ParseDigest(cfg.GetString("Digest")); err != nil {
+			return nil, err
 		}
 	}
 
-	constants.MaxPayloadPerFrame = ((encoder.frameHeight * encoder.frameWidth) / 8) - constants.HeaderSize
+	if err = encoder.layout.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid frame layout: %w", err)
+	}
 
-	return encoder
+	return encoder, nil
+}
+
+// EncodeOptions holds the per-file encoding settings
+type EncodeOptions struct {
+	// free-form key/value tags stored in the manifest
+	Tags map[string]string
+
+	// encrypts the video with a key derived from the passphrase when set
+	Passphrase []byte
+
+	// encrypts the video for the recipient public keys when set, along with the passphrase if any
+	Recipients []encryption.Recipient
+
+	// signs the frames of the video when set
+	SignKey *signature.PrivateKey
 }
 
 // EncodeFile encodes any file type into an MP4 video file
-func (e *VideoEncoder) EncodeFile(inputPath, outputVideo string) error {
+func (e *VideoEncoder) EncodeFile(inputPath, outputVideo string, opts EncodeOptions) error {
+	var (
+		err          error
+		inputFile    *os.File
+		fileManifest manifest.Manifest
+	)
+
+	if inputFile, err = os.Open(inputPath); err != nil {
+		return fmt.Errorf("failed to open input file: %w", err)
+	}
+
+	defer inputFile.Close()
+
+	// name, mode, mtime, MIME type and digest of the original file
+	if fileManifest, err = manifest.FromFile(inputFile, e.digest, opts.Tags); err != nil {
+		return fmt.Errorf("failed to build manifest: %w", err)
+	}
+
+	return e.encode(func() (io.ReadCloser, error) { return os.Open(inputPath) }, fileManifest, nil, outputVideo, opts)
+}
+
+// EncodeReader encodes a data stream of unknown size (such as stdin) into an MP4 video file, the
+// manifest recording the given name. The stream is spooled to a temp file while it is hashed since
+// its size and digest are written before its data.
+func (e *VideoEncoder) EncodeReader(input io.Reader, name, outputVideo string, opts EncodeOptions) error {
+	var (
+		err          error
+		spool        *os.File
+		fileManifest manifest.Manifest
+	)
+
+	if spool, err = os.CreateTemp("", "data2vid-input-*"); err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	if fileManifest, err = manifest.FromReader(io.TeeReader(input, spool), name, e.digest, opts.Tags); err != nil {
+		return fmt.Errorf("failed to build manifest: %w", err)
+	}
+
+	return e.encode(func() (io.ReadCloser, error) { return os.Open(spool.Name()) }, fileManifest, nil, outputVideo, opts)
+}
+
+// EncodeArchive encodes files and directory trees into a single MP4 video file, with a
+// table of contents preserving their relative paths, modes, symlinks and timestamps
+func (e *VideoEncoder) EncodeArchive(inputPaths []string, outputVideo string, opts EncodeOptions) error {
+	var (
+		err             error
+		toc             archive.TOC
+		archiveManifest manifest.Manifest
+		tocData         []byte
+		baseName        = filepath.Base(outputVideo)
+	)
+
+	if toc, err = archive.Collect(inputPaths); err != nil {
+		return err
+	}
+
+	// the archive is named after the video, its data is the concatenation of the files
+	if archiveManifest, err = manifest.FromReader(toc.Reader(), strings.TrimSuffix(baseName, filepath.Ext(baseName)), e.digest, opts.Tags); err != nil {
+		return fmt.Errorf("failed to build manifest: %w", err)
+	}
+
+	archiveManifest.MIMEType = archive.MIMEType
+	archiveManifest.Archive = true
+
+	if tocData, err = toc.Marshal(); err != nil {
+		return fmt.Errorf("failed to encode table of contents: %w", err)
+	}
+
+	return e.encode(func() (io.ReadCloser, error) { return io.NopCloser(toc.Reader()), nil }, archiveManifest, tocData, outputVideo, opts)
+}
+
+// encode writes the key frames of encrypted videos, the manifest frames, the table of contents
+// frames of archives, then the (compressed, encrypted) data frames of the input into an MP4 video file
+func (e *VideoEncoder) encode(open opener, fileManifest manifest.Manifest, tocData []byte, outputVideo string, opts EncodeOptions) error {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
 
 	var (
-		tempDir    string
-		err        error
-		inputFile  *os.File
-		fileInfo   os.FileInfo
-		framePaths []string
+		err            error
+		manifestData   []byte
+		envelopeData   []byte
+		signatureData  []byte
+		key            *encryption.Key
+		sink           *frame.Sink
+		writer         *video.Writer
+		finished       bool
+		input          io.ReadCloser
+		data           io.Reader
+		header         = frame.Header{Layout: e.layout, TotalSize: fileManifest.Size, ChecksumAlgorithm: e.checksum}
+		metadataHeader = frame.Header{Layout: e.layout, ChecksumAlgorithm: e.checksum}
 	)
 
-	// temp dir
-	if tempDir, err = os.MkdirTemp("", "ytdata"); err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
+	if manifestData, err = fileManifest.Marshal(); err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
 	}
 
-	e.tempDir = tempDir
+	if key, envelopeData, err = e.newEnvelope(opts); err != nil {
+		return fmt.Errorf("encryption error: %w", err)
+	}
 
-	defer os.RemoveAll(tempDir)
+	// file name, hash, tags and paths are confidential too
+	if key != nil {
+		if manifestData, err = key.Seal(manifestData, "manifest"); err == nil && len(tocData) > 0 {
+			tocData, err = key.Seal(tocData, "toc")
+		}
 
-	if inputFile, err = os.Open(inputPath); err != nil {
-		return fmt.Errorf("failed to open input file: %w", err)
+		if err != nil {
+			return fmt.Errorf("encryption error: %w", err)
+		}
 	}
 
-	defer inputFile.Close()
+	// data stream compressed then framed, the frame format is described in the frame package
+	if input, header, err = e.compressData(open, header); err != nil {
+		return fmt.Errorf("compression failed: %w", err)
+	}
+
+	defer input.Close()
+
+	// video from frames streamed to ffmpeg: libx264 codec with yuv420p (gray for multi-level
+	// frames, libx264rgb with rgb24 when the color channels carry data)
+	if writer, err = e.newWriter(outputVideo); err != nil {
+		return fmt.Errorf("failed to create video: %w", err)
+	}
+
+	// no partial video left behind
+	defer func() {
+		if !finished {
+			writer.Abort()
+		}
+	}()
+
+	// frames rendered concurrently, written in order
+	sink = frame.NewSink(writer, e.workers)
+
+	defer sink.Close()
+
+	if key != nil {
+		metadataHeader.Kind = types.KindKeys
+
+		if err = frame.CreateMetadataFrames(sink, envelopeData, metadataHeader); err != nil {
+			return fmt.Errorf("failed to create key frames: %w", err)
+		}
+
+		metadataHeader.Flags |= frame.FlagEncrypted
+	}
+
+	metadataHeader.Kind = types.KindManifest
 
-	if fileInfo, err = inputFile.Stat(); err != nil {
-		return fmt.Errorf("failed to get file info: %w", err)
-	}
-
-	// Create frame images (PNG) from the file data chunks  ->
-	// Frame Format Design:
-	//
-	// 1. Header Structure (32 bytes total):
-	//
-	// +-------------+-------------+-------------+-------------+-------------+------------+
-	// | Magic String|  Total Size | Sequence #  | Chunk Size  |    Data     |  Header    |
-	// | (6 bytes)   |  (8 bytes)  | (4 bytes)   | (4 bytes)   |  Checksum   |  Checksum  |
-	// |             |             |             |             |  (8 bytes)  |  (2 bytes) |
-	// +-------------+-------------+-------------+-------------+-------------+-------------+
-	// | 0     5     | 6        13 | 14      17  | 18      21  | 22       29 | 30      31  |
-	// +-------------+-------------+-------------+-------------+-------------+-------------+
-	//
-	// 2. Data Encoding:
-	//
-	// +---------------------------+
-	// |        Frame Header       |  32 bytes
-	// +---------------------------+
-	// |                           |
-	// |         Payload           |  Variable length (up to maxPayloadPerFrame)
-	// |                           |
-	// +---------------------------+
-	//
-	// 3. Pixel Mapping:
-	//
-	// Each byte of combined header+data is split into 8 bits
-	// Each bit is represented as one pixel in the image:
-	//   - Bit 0 = White pixel
-	//   - Bit 1 = Black pixel
-	//
-	// +---+---+---+---+---+---+---+---+---+---+---+
-	// | 0 | 1 | 0 | 1 | 0 | 0 | 1 | 1 | 0 | 1 | ...  Bits from header and data
-	// +---+---+---+---+---+---+---+---+---+---+---+
-	//   ↓   ↓   ↓   ↓   ↓   ↓   ↓   ↓   ↓   ↓
-	// +---+---+---+---+---+---+---+---+---+---+---+
-	// | W | B | W | B | W | W | B | B | W | B | ...  Pixels in image (W=White, B=Black)
-	// +---+---+---+---+---+---+---+---+---+---+---+
-	//
-	// Pixels are filled row by row, left to right, top to bottom:
-	//
-	// +---+---+---+---+---+
-	// | 0 | 1 | 2 | 3 | 4 |
-	// +---+---+---+---+---+
-	// | 5 | 6 | 7 | 8 | 9 |
-	// +---+---+---+---+---+
-	// |10 |11 |12 |13 |14 |
-	// +---+---+---+---+---+
-	//
-	// For a 1280x720 frame, this allows storing approximately 115,168 bytes of data
-	// (1280*720/8 bits - 32 bytes for the header)
-	if framePaths, err = e.createFrames(inputFile, fileInfo.Size()); err != nil {
+	if err = frame.CreateMetadataFrames(sink, manifestData, metadataHeader); err != nil {
+		return fmt.Errorf("failed to create manifest frames: %w", err)
+	}
+
+	if len(tocData) > 0 {
+		metadataHeader.Kind = types.KindTOC
+
+		if err = frame.CreateMetadataFrames(sink, tocData, metadataHeader); err != nil {
+			return fmt.Errorf("failed to create table of contents frames: %w", err)
+		}
+	}
+
+	data = input
+
+	if key != nil {
+		if data, err = key.NewEncryptReader(input, header.TotalSize, "data"); err != nil {
+			return fmt.Errorf("encryption error: %w", err)
+		}
+
+		header.TotalSize = encryption.EncryptedSize(header.TotalSize)
+		header.Flags |= frame.FlagEncrypted
+	}
+
+	if err = frame.CreateFrames(sink, data, header); err != nil {
 		return fmt.Errorf("failed to create frames: %w", err)
 	}
 
-	// video from frames : using ffmpeg pkg: libx264 codec with yuv420p
-	if err = e.createVideo(framePaths, outputVideo); err != nil {
+	// Ed25519 signature of the SHA-256 digests of every frame payload, with their kind and sequence
+	// number, written last and never encrypted: modified, forged or swapped frames are detected
+	if opts.SignKey != nil {
+		metadataHeader.Kind = types.KindSignature
+		metadataHeader.Flags = 0
+
+		if signatureData, err = signature.Sign(*opts.SignKey, sink.Digests).Marshal(); err != nil {
+			return fmt.Errorf("failed to encode signature: %w", err)
+		}
+
+		if err = frame.CreateMetadataFrames(sink, signatureData, metadataHeader); err != nil {
+			return fmt.Errorf("failed to create signature frames: %w", err)
+		}
+	}
+
+	if err = sink.Close(); err != nil {
+		return fmt.Errorf("failed to create frames: %w", err)
+	}
+
+	if err = writer.Close(); err != nil {
 		return fmt.Errorf("failed to create video: %w", err)
 	}
 
+	finished = true
+
 	return nil
 }
 
-// DecodeFile extracts and reconstructs the original file from video frames
-func (e *VideoEncoder) DecodeFile(videoPath, outputPath string) error {
+// Layout returns the frame layout used by the encoder
+func (e *VideoEncoder) Layout() frame.Layout {
+	return e.layout
+}
+
+// DecodeFile extracts and reconstructs the original file from video frames, an empty output
+// path restores the file name recorded in the manifest. Archives are extracted below the
+// output path (the current directory when empty).
+func (e *VideoEncoder) DecodeFile(videoPath, outputPath string, opts types.DecodeOptions) (types.DecodeReport, error) {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
 
-	return video.DecodeFile(e, videoPath, outputPath)
+	if opts.Workers == 0 {
+		opts.Workers = e.workers
+	}
+
+	return video.DecodeFile(e, videoPath, outputPath, opts)
 }
 
-// createFrames generates PNG frames from file data
-func (e *VideoEncoder) createFrames(input io.Reader, fileSize int64) ([]string, error) {
-	return frame.CreateFrames(e.tempDir, input, fileSize, e.frameWidth, e.frameHeight)
+// newEnvelope generates the file key of an encrypted video and its envelope, wrapping it for
+// the passphrase (no key when the video is not encrypted)
+func (e *VideoEncoder) newEnvelope(opts EncodeOptions) (*encryption.Key, []byte, error) {
+	var (
+		key      encryption.Key
+		envelope = encryption.Envelope{Cipher: e.cipher}
+		stanza   encryption.Stanza
+		data     []byte
+		err      error
+	)
+
+	if len(opts.Passphrase) == 0 && len(opts.Recipients) == 0 {
+		return nil, nil, nil
+	}
+
+	if key, err = encryption.NewKey(e.cipher); err != nil {
+		return nil, nil, err
+	}
+
+	if len(opts.Passphrase) != 0 {
+		if stanza, err = key.PassphraseStanza(opts.Passphrase); err != nil {
+			return nil, nil, err
+		}
+
+		envelope.Stanzas = append(envelope.Stanzas, stanza)
+	}
+
+	for _, recipient := range opts.Recipients {
+		if stanza, err = key.RecipientStanza(recipient); err != nil {
+			return nil, nil, err
+		}
+
+		envelope.Stanzas = append(envelope.Stanzas, stanza)
+	}
+
+	if data, err = envelope.Marshal(); err != nil {
+		return nil, nil, err
+	}
+
+	return &key, data, nil
 }
 
-// createVideo combines frames into a video file
-func (e *VideoEncoder) createVideo(framePaths []string, outputVideo string) error {
-	return video.CreateVideo(e.tempDir, framePaths, outputVideo)
+// compressData returns the data stream to frame and its header template: the data compressed
+// while it is framed, its size measured by a first compression pass, or the data as is when
+// compression is disabled or does not reduce its size
+func (e *VideoEncoder) compressData(open opener, header frame.Header) (io.ReadCloser, frame.Header, error) {
+	var (
+		source io.ReadCloser
+		size   int64
+		err    error
+	)
+
+	if e.compression == compress.None {
+		source, err = open()
+
+		return source, header, err
+	}
+
+	// first pass: compressed size only, frame headers need it before the first frame
+	if size, err = e.compressTo(io.Discard, open); err != nil {
+		return nil, header, err
+	}
+
+	// incompressible data => stored as is
+	if uint64(size) >= header.TotalSize {
+		source, err = open()
+
+		return source, header, err
+	}
+
+	reader, writer := io.Pipe()
+
+	// second pass: compressed again while the frames read it
+	go func() {
+		written, err := e.compressTo(writer, open)
+		if err == nil && written != size {
+			err = fmt.Errorf("compressed size changed from %d to %d bytes", size, written)
+		}
+
+		writer.CloseWithError(err)
+	}()
+
+	header.Compression = e.compression
+	header.TotalSize = uint64(size)
+
+	return reader, header, nil
+}
+
+// compressTo compresses the data into w and returns the compressed size
+func (e *VideoEncoder) compressTo(w io.Writer, open opener) (int64, error) {
+	var (
+		source  io.ReadCloser
+		writer  io.WriteCloser
+		counter = &countingWriter{w: w}
+		err     error
+	)
+
+	if source, err = open(); err != nil {
+		return 0, err
+	}
+
+	defer source.Close()
+
+	if writer, err = e.compression.NewWriter(counter); err != nil {
+		return 0, err
+	}
+
+	if _, err = io.Copy(writer, source); err != nil {
+		writer.Close()
+
+		return 0, err
+	}
+
+	if err = writer.Close(); err != nil {
+		return 0, err
+	}
+
+	return counter.n, nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+// newWriter starts the ffmpeg process encoding the streamed frames into a video file
+func (e *VideoEncoder) newWriter(outputVideo string) (*video.Writer, error) {
+	codec, pixelFormat := e.outputFormat()
+
+	return video.NewWriter(outputVideo, e.layout.Width, e.layout.Height, e.frameRate,
+		e.layout.ColorMode.RawPixelFormat(), codec, pixelFormat)
+}
+
+// outputFormat returns the ffmpeg codec and pixel format of the encoded video: gray levels
+// need an exact luma round trip (no limited range conversion) as soon as more than 2 levels
+// are used, and libx264rgb keeps the R, G, B planes lossless (no YUV conversion)
+func (e *VideoEncoder) outputFormat() (string, string) {
+	switch {
+	case e.layout.ColorMode == frame.ColorRGB:
+		return "libx264rgb", "rgb24"
+	case e.layout.BitsPerPixel > 1:
+		return "libx264", "gray"
+	}
+
+	return "libx264", "yuv420p"
 }
 
-// ProcessFrameWithSequence extracts data from a frame and returns the payload  total size and sequence number
-func (e *VideoEncoder) ProcessFrameWithSequence(framePath string) ([]byte, uint64, int, error) {
-	return frame.ProcessFrameWithSequence(framePath, e.frameWidth, e.frameHeight)
+// ProcessFrame decodes a video frame image: its kind, sequence number, total size and payload
+func (e *VideoEncoder) ProcessFrame(img image.Image) (types.Frame, error) {
+	return frame.ProcessFrame(img)
 }
@@ -0,0 +1,109 @@
+package encoder
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"testing"
+	"testing/iotest"
+
+	"github.com/sabouaram/data2vid/internal/compress"
+	"github.com/sabouaram/data2vid/internal/frame"
+)
+
+// bytesOpener opens data, counting the passes over it
+func bytesOpener(data []byte, opened *int) opener {
+	return func() (io.ReadCloser, error) {
+		*opened++
+
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+// the compressed stream is measured by a first pass, then streamed by a second one
+func TestCompressData(t *testing.T) {
+	var (
+		compressible = bytes.Repeat([]byte("data2vid compressed stream "), 20000)
+		random       = make([]byte, 100000)
+	)
+
+	rand.NewChaCha8([32]byte{}).Read(random)
+
+	tests := []struct {
+		name        string
+		compression compress.Algorithm
+		data        []byte
+		want        compress.Algorithm
+		passes      int
+	}{
+		{"none", compress.None, compressible, compress.None, 1},
+		{"gzip", compress.Gzip, compressible, compress.Gzip, 2},
+		{"zstd", compress.Zstd, compressible, compress.Zstd, 2},
+		{"incompressible", compress.Zstd, random, compress.None, 2},
+		{"empty", compress.Gzip, nil, compress.None, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				e      = &VideoEncoder{compression: tt.compression}
+				opened = 0
+			)
+
+			input, header, err := e.compressData(bytesOpener(tt.data, &opened), frame.Header{TotalSize: uint64(len(tt.data))})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer input.Close()
+
+			stream, err := io.ReadAll(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if header.Compression != tt.want || opened != tt.passes || header.TotalSize != uint64(len(stream)) {
+				t.Fatalf("%s in %d passes, %d bytes for a %d bytes stream", header.Compression, opened, header.TotalSize, len(stream))
+			}
+
+			reader, err := header.Compression.NewReader(bytes.NewReader(stream))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if data, err := io.ReadAll(reader); err != nil || !bytes.Equal(data, tt.data) {
+				t.Fatalf("data not restored: %v", err)
+			}
+		})
+	}
+}
+
+// a source failing during the second pass fails the stream
+func TestCompressDataReadError(t *testing.T) {
+	var (
+		data    = bytes.Repeat([]byte("data2vid "), 10000)
+		errRead = errors.New("read error")
+		opened  = 0
+		e       = &VideoEncoder{compression: compress.Gzip}
+	)
+
+	open := func() (io.ReadCloser, error) {
+		if opened++; opened == 2 {
+			return io.NopCloser(io.MultiReader(bytes.NewReader(data[:100]), iotest.ErrReader(errRead))), nil
+		}
+
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	input, _, err := e.compressData(open, frame.Header{TotalSize: uint64(len(data))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer input.Close()
+
+	if _, err = io.ReadAll(input); !errors.Is(err, errRead) {
+		t.Fatalf("got %v, want %v", err, errRead)
+	}
+}
@@ -0,0 +1,347 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// ChunkSize is the plaintext size of each independently authenticated chunk of a stream
+	ChunkSize = 64 * 1024
+
+	// Overhead is the authentication tag size added to every chunk
+	Overhead = 16
+
+	// KeySize is the size of the file key and of every key derived from it
+	KeySize = 32
+)
+
+var (
+	// ErrAuthentication is returned when a chunk fails authentication: modified frames,
+	// or frames of another video
+	ErrAuthentication = errors.New("authentication failed")
+)
+
+// Cipher is the AEAD protecting the encrypted streams of a video
+type Cipher string
+
+const (
+	ChaCha20Poly1305 Cipher = "chacha20-poly1305"
+	AES256GCM        Cipher = "aes-256-gcm"
+)
+
+// ParseCipher converts a config value to a Cipher, ChaCha20-Poly1305 by default
+func ParseCipher(name string) (Cipher, error) {
+	switch Cipher(strings.ToLower(name)) {
+	case "", ChaCha20Poly1305:
+		return ChaCha20Poly1305, nil
+	case AES256GCM:
+		return AES256GCM, nil
+	}
+
+	return "", fmt.Errorf("unsupported cipher %q (expected %s or %s)", name, ChaCha20Poly1305, AES256GCM)
+}
+
+// Key is the random per-file key, every stream of the video (data, manifest, table of
+// contents) is encrypted with its own key derived from it
+type Key struct {
+	cipher Cipher
+	secret []byte
+}
+
+// NewKey generates a random file key
+func NewKey(c Cipher) (Key, error) {
+	secret := make([]byte, KeySize)
+
+	if _, err := rand.Read(secret); err != nil {
+		return Key{}, fmt.Errorf("failed to generate file key: %w", err)
+	}
+
+	return Key{cipher: c, secret: secret}, nil
+}
+
+// aead returns the AEAD of one stream, keyed by HKDF-SHA256(file key, purpose)
+func (k Key) aead(purpose string) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, k.secret, nil, "data2vid "+purpose, KeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	switch k.cipher {
+	case ChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	case AES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		return cipher.NewGCM(block)
+	}
+
+	return nil, fmt.Errorf("unsupported cipher %q", k.cipher)
+}
+
+// EncryptedSize returns the size of an encrypted stream of size plaintext bytes
+func EncryptedSize(size uint64) uint64 {
+	return size + chunks(size)*Overhead
+}
+
+// chunks returns the number of chunks of a stream, an empty stream still has one chunk
+func chunks(size uint64) uint64 {
+	return max(1, (size+ChunkSize-1)/ChunkSize)
+}
+
+// nonce returns the nonce of a chunk: an 11 bytes big-endian counter followed by a byte set on
+// the last chunk, so that reordered, duplicated or truncated chunks fail authentication
+func nonce(counter uint64, last bool) []byte {
+	n := make([]byte, 12)
+
+	binary.BigEndian.PutUint64(n[3:11], counter)
+
+	if last {
+		n[11] = 1
+	}
+
+	return n
+}
+
+// NewEncryptReader returns a reader encrypting the size bytes of r chunk by chunk
+func (k Key) NewEncryptReader(r io.Reader, size uint64, purpose string) (io.Reader, error) {
+	aead, err := k.aead(purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptReader{
+		source: r,
+		aead:   aead,
+		total:  chunks(size),
+		left:   size,
+		plain:  make([]byte, ChunkSize),
+		buffer: make([]byte, 0, ChunkSize+Overhead),
+	}, nil
+}
+
+type encryptReader struct {
+	source  io.Reader
+	aead    cipher.AEAD
+	counter uint64
+	total   uint64
+	left    uint64
+	plain   []byte
+	buffer  []byte
+	sealed  []byte
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	if len(r.sealed) == 0 {
+		if r.counter == r.total {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(r.source, r.plain[:min(ChunkSize, r.left)])
+		if err != nil {
+			return 0, fmt.Errorf("read error: %w", err)
+		}
+
+		r.left -= uint64(n)
+		last := r.counter == r.total-1
+
+		r.sealed = r.aead.Seal(r.buffer[:0], nonce(r.counter, last), r.plain[:n], nil)
+		r.counter++
+	}
+
+	n := copy(p, r.sealed)
+	r.sealed = r.sealed[n:]
+
+	return n, nil
+}
+
+// Seal encrypts an in-memory stream
+func (k Key) Seal(data []byte, purpose string) ([]byte, error) {
+	reader, err := k.NewEncryptReader(bytes.NewReader(data), uint64(len(data)), purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(reader)
+}
+
+// NewDecryptReader returns a reader decrypting and authenticating the size bytes of an encrypted
+// stream chunk by chunk, a chunk is only returned once authenticated
+func (k Key) NewDecryptReader(r io.Reader, size uint64, purpose string) (io.Reader, error) {
+	sealed := uint64(ChunkSize + Overhead)
+
+	aead, err := k.aead(purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	if size == 0 {
+		return nil, fmt.Errorf("%w: empty %s stream", ErrAuthentication, purpose)
+	}
+
+	return &decryptReader{
+		source:  r,
+		aead:    aead,
+		purpose: purpose,
+		total:   (size + sealed - 1) / sealed,
+		left:    size,
+		sealed:  make([]byte, sealed),
+		buffer:  make([]byte, 0, ChunkSize),
+	}, nil
+}
+
+// NewSalvageReader returns a decrypting reader like NewDecryptReader, except that chunks failing
+// authentication are read as zeros and reported to damaged with their plaintext byte range
+func (k Key) NewSalvageReader(r io.Reader, size uint64, purpose string, damaged func(start, end uint64)) (io.Reader, error) {
+	reader, err := k.NewDecryptReader(r, size, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	reader.(*decryptReader).damaged = damaged
+
+	return reader, nil
+}
+
+type decryptReader struct {
+	source  io.Reader
+	aead    cipher.AEAD
+	purpose string
+	damaged func(start, end uint64)
+	counter uint64
+	total   uint64
+	left    uint64
+	sealed  []byte
+	buffer  []byte
+	plain   []byte
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	if len(r.plain) == 0 {
+		if r.counter == r.total {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(r.source, r.sealed[:min(uint64(len(r.sealed)), r.left)])
+		if err != nil {
+			return 0, fmt.Errorf("read error: %w", err)
+		}
+
+		r.left -= uint64(n)
+		last := r.counter == r.total-1
+
+		if r.plain, err = r.aead.Open(r.buffer[:0], nonce(r.counter, last), r.sealed[:n], nil); err != nil && r.damaged != nil {
+			r.plain = r.buffer[:max(n-Overhead, 0)]
+			clear(r.plain)
+
+			r.damaged(r.counter*ChunkSize, r.counter*ChunkSize+uint64(len(r.plain)))
+		} else if err != nil {
+			return 0, fmt.Errorf("%w: %s chunk %d (bytes %d-%d) was modified or does not belong to this video",
+				ErrAuthentication, r.purpose, r.counter, r.counter*ChunkSize, (r.counter+1)*ChunkSize-1)
+		}
+
+		r.counter++
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+
+	return n, nil
+}
+
+// Open decrypts and authenticates an in-memory stream
+func (k Key) Open(data []byte, purpose string) ([]byte, error) {
+	reader, err := k.NewDecryptReader(bytes.NewReader(data), uint64(len(data)), purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(reader)
+}
+
+// Envelope is stored as JSON in the key frames: the cipher of the video and the file key
+// wrapped once for every way of unlocking it
+type Envelope struct {
+	Cipher  Cipher   `json:"cipher"`
+	Stanzas []Stanza `json:"stanzas"`
+}
+
+// Stanza holds the file key wrapped for one passphrase or recipient
+type Stanza struct {
+	Type         string `json:"type"`
+	Salt         []byte `json:"salt,omitempty"`
+	LogN         int    `json:"log_n,omitempty"`
+	EphemeralKey []byte `json:"ephemeral,omitempty"`
+	WrappedKey   []byte `json:"key"`
+}
+
+// Marshal encodes the envelope into its frame payload
+func (e Envelope) Marshal() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// ParseEnvelope decodes a key frame payload
+func ParseEnvelope(data []byte) (Envelope, error) {
+	var e Envelope
+
+	if err := json.Unmarshal(data, &e); err != nil {
+		return e, fmt.Errorf("invalid encryption envelope: %w", err)
+	}
+
+	if _, err := ParseCipher(string(e.Cipher)); err != nil {
+		return e, err
+	}
+
+	return e, nil
+}
+
+// Has reports whether the envelope holds a stanza of the given type
+func (e Envelope) Has(stanzaType string) bool {
+	for _, stanza := range e.Stanzas {
+		if stanza.Type == stanzaType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wrap encrypts the file key with a single-use key encryption key
+func wrap(kek, secret []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), secret, nil), nil
+}
+
+// unwrap decrypts a wrapped file key, false when the key encryption key is not the right one
+func unwrap(kek, wrapped []byte) ([]byte, bool) {
+	aead, err := chacha20poly1305.New(kek)
+	if err != nil {
+		return nil, false
+	}
+
+	secret, err := aead.Open(nil, make([]byte, aead.NonceSize()), wrapped, nil)
+	if err != nil || len(secret) != KeySize {
+		return nil, false
+	}
+
+	return secret, true
+}
@@ -0,0 +1,172 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// sealed is the size of a full encrypted chunk
+const sealed = ChunkSize + Overhead
+
+// testKey returns a key with a fixed secret so that failures are reproducible
+func testKey(c Cipher, seed byte) Key {
+	return Key{cipher: c, secret: bytes.Repeat([]byte{seed}, KeySize)}
+}
+
+// testData returns size bytes of deterministic random data
+func testData(size int) []byte {
+	data := make([]byte, size)
+
+	rand.NewChaCha8([32]byte{}).Read(data)
+
+	return data
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	for _, c := range []Cipher{ChaCha20Poly1305, AES256GCM} {
+		for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3*ChunkSize + 100} {
+			var (
+				key  = testKey(c, 1)
+				data = testData(size)
+			)
+
+			encrypted, err := key.Seal(data, "data")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if uint64(len(encrypted)) != EncryptedSize(uint64(size)) {
+				t.Fatalf("%s %d bytes: %d encrypted bytes, expected %d", c, size, len(encrypted), EncryptedSize(uint64(size)))
+			}
+
+			decrypted, err := key.Open(encrypted, "data")
+			if err != nil || !bytes.Equal(decrypted, data) {
+				t.Fatalf("%s %d bytes: not decrypted (%v)", c, size, err)
+			}
+		}
+	}
+}
+
+func TestDecryptRejectsModifiedStreams(t *testing.T) {
+	var (
+		key  = testKey(ChaCha20Poly1305, 1)
+		data = testData(3*ChunkSize + 100)
+	)
+
+	encrypted, err := key.Seal(data, "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     Key
+		purpose string
+		modify  func(stream []byte) []byte
+	}{
+		{"flipped byte", key, "data", func(stream []byte) []byte {
+			stream[sealed+10] ^= 1
+			return stream
+		}},
+		{"flipped tag byte", key, "data", func(stream []byte) []byte {
+			stream[len(stream)-1] ^= 0x80
+			return stream
+		}},
+		{"swapped chunks", key, "data", func(stream []byte) []byte {
+			return slices.Concat(stream[sealed:2*sealed], stream[:sealed], stream[2*sealed:])
+		}},
+		{"duplicated chunk", key, "data", func(stream []byte) []byte {
+			return slices.Concat(stream[:sealed], stream[:sealed], stream[2*sealed:])
+		}},
+		// the stream ends on a full chunk not sealed with the last-chunk flag
+		{"truncated final chunk", key, "data", func(stream []byte) []byte {
+			return stream[:3*sealed]
+		}},
+		{"truncated final chunk bytes", key, "data", func(stream []byte) []byte {
+			return stream[:len(stream)-10]
+		}},
+		{"wrong purpose", key, "manifest", func(stream []byte) []byte { return stream }},
+		{"wrong key", testKey(ChaCha20Poly1305, 2), "data", func(stream []byte) []byte { return stream }},
+		{"wrong cipher", testKey(AES256GCM, 1), "data", func(stream []byte) []byte { return stream }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := tt.modify(bytes.Clone(encrypted))
+
+			if _, err := tt.key.Open(stream, tt.purpose); !errors.Is(err, ErrAuthentication) {
+				t.Fatalf("got %v, expected %v", err, ErrAuthentication)
+			}
+		})
+	}
+}
+
+func TestSalvageReader(t *testing.T) {
+	var (
+		key  = testKey(AES256GCM, 1)
+		data = testData(3*ChunkSize + 100)
+	)
+
+	encrypted, err := key.Seal(data, "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(stream []byte) []byte
+		damaged [][2]uint64
+	}{
+		{"intact", func(stream []byte) []byte { return stream }, nil},
+		{"flipped byte", func(stream []byte) []byte {
+			stream[sealed+10] ^= 1
+			return stream
+		}, [][2]uint64{{ChunkSize, 2 * ChunkSize}}},
+		{"flipped byte in the final chunk", func(stream []byte) []byte {
+			stream[3*sealed] ^= 1
+			return stream
+		}, [][2]uint64{{3 * ChunkSize, 3*ChunkSize + 100}}},
+		{"swapped chunks", func(stream []byte) []byte {
+			return slices.Concat(stream[:sealed], stream[2*sealed:3*sealed], stream[sealed:2*sealed], stream[3*sealed:])
+		}, [][2]uint64{{ChunkSize, 2 * ChunkSize}, {2 * ChunkSize, 3 * ChunkSize}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				stream  = tt.modify(bytes.Clone(encrypted))
+				damaged [][2]uint64
+			)
+
+			reader, err := key.NewSalvageReader(bytes.NewReader(stream), uint64(len(stream)), "data", func(start, end uint64) {
+				damaged = append(damaged, [2]uint64{start, end})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			salvaged, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(damaged, tt.damaged) {
+				t.Fatalf("damaged ranges %v, expected %v", damaged, tt.damaged)
+			}
+
+			expected := bytes.Clone(data)
+
+			for _, r := range damaged {
+				clear(expected[r[0]:r[1]])
+			}
+
+			if !bytes.Equal(salvaged, expected) {
+				t.Fatal("intact chunks not decrypted or damaged chunks not zeroed")
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// StanzaScrypt wraps the file key with a key derived from a passphrase
+	StanzaScrypt = "scrypt"
+
+	// scrypt work factor: N = 2^18, r = 8, p = 1 (~256 MiB and ~1s per derivation)
+	scryptLogN = 18
+
+	// largest work factor accepted on decode, so a crafted video cannot exhaust memory
+	maxScryptLogN = 22
+)
+
+var (
+	// ErrWrongPassphrase is returned when no stanza can be unlocked with the passphrase
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+
+	// ErrNoPassphraseStanza is returned when the video is not encrypted with a passphrase
+	ErrNoPassphraseStanza = errors.New("the video is not encrypted with a passphrase")
+)
+
+// PassphraseStanza wraps the file key with a key derived from the passphrase by scrypt
+func (k Key) PassphraseStanza(passphrase []byte) (Stanza, error) {
+	var (
+		salt = make([]byte, 16)
+		kek  []byte
+		err  error
+	)
+
+	if len(passphrase) == 0 {
+		return Stanza{}, errors.New("empty passphrase")
+	}
+
+	if _, err = rand.Read(salt); err != nil {
+		return Stanza{}, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	if kek, err = scrypt.Key(passphrase, salt, 1<<scryptLogN, 8, 1, KeySize); err != nil {
+		return Stanza{}, err
+	}
+
+	wrapped, err := wrap(kek, k.secret)
+	if err != nil {
+		return Stanza{}, err
+	}
+
+	return Stanza{Type: StanzaScrypt, Salt: salt, LogN: scryptLogN, WrappedKey: wrapped}, nil
+}
+
+// UnlockPassphrase returns the file key unwrapped from a passphrase stanza of the envelope
+func (e Envelope) UnlockPassphrase(passphrase []byte) (Key, error) {
+	var found bool
+
+	for _, stanza := range e.Stanzas {
+		if stanza.Type != StanzaScrypt {
+			continue
+		}
+
+		found = true
+
+		if stanza.LogN <= 0 || stanza.LogN > maxScryptLogN {
+			return Key{}, fmt.Errorf("invalid scrypt work factor 2^%d", stanza.LogN)
+		}
+
+		kek, err := scrypt.Key(passphrase, stanza.Salt, 1<<stanza.LogN, 8, 1, KeySize)
+		if err != nil {
+			return Key{}, err
+		}
+
+		if secret, ok := unwrap(kek, stanza.WrappedKey); ok {
+			return Key{cipher: e.Cipher, secret: secret}, nil
+		}
+	}
+
+	if !found {
+		return Key{}, ErrNoPassphraseStanza
+	}
+
+	return Key{}, ErrWrongPassphrase
+}
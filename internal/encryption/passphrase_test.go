@@ -0,0 +1,82 @@
+package encryption
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// cheapPassphraseStanza wraps the key like PassphraseStanza with a low work factor, to keep
+// the tests fast
+func cheapPassphraseStanza(t *testing.T, k Key, passphrase string) Stanza {
+	t.Helper()
+
+	var (
+		salt = []byte("0123456789abcdef")
+		logN = 10
+	)
+
+	kek, err := scrypt.Key([]byte(passphrase), salt, 1<<logN, 8, 1, KeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrapped, err := wrap(kek, k.secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Stanza{Type: StanzaScrypt, Salt: salt, LogN: logN, WrappedKey: wrapped}
+}
+
+func TestUnlockPassphrase(t *testing.T) {
+	var (
+		key      = testKey(AES256GCM, 1)
+		stanza   = cheapPassphraseStanza(t, key, "correct horse")
+		other    = cheapPassphraseStanza(t, testKey(AES256GCM, 2), "battery staple")
+		tooSlow  = stanza
+		envelope = Envelope{Cipher: AES256GCM, Stanzas: []Stanza{stanza}}
+	)
+
+	tooSlow.LogN = maxScryptLogN + 1
+
+	encrypted, err := key.Seal([]byte("secret data"), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		envelope   Envelope
+		passphrase string
+		err        error
+	}{
+		{"passphrase", envelope, "correct horse", nil},
+		{"second stanza", Envelope{Cipher: AES256GCM, Stanzas: []Stanza{other, stanza}}, "correct horse", nil},
+		{"wrong passphrase", envelope, "correct horse ", ErrWrongPassphrase},
+		{"empty passphrase", envelope, "", ErrWrongPassphrase},
+		{"recipients only", Envelope{Cipher: AES256GCM, Stanzas: []Stanza{{Type: StanzaX25519}}}, "correct horse", ErrNoPassphraseStanza},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unlocked, err := tt.envelope.UnlockPassphrase([]byte(tt.passphrase))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, expected %v", err, tt.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if data, err := unlocked.Open(encrypted, "data"); err != nil || string(data) != "secret data" {
+				t.Fatalf("data not decrypted with the unlocked key: %v", err)
+			}
+		})
+	}
+
+	if _, err := (Envelope{Cipher: AES256GCM, Stanzas: []Stanza{tooSlow}}).UnlockPassphrase([]byte("correct horse")); err == nil {
+		t.Fatal("excessive work factor accepted")
+	}
+}
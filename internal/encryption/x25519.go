@@ -0,0 +1,177 @@
+package encryption
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/sabouaram/data2vid/internal/keys"
+)
+
+const (
+	// StanzaX25519 wraps the file key for a recipient public key
+	StanzaX25519 = "x25519"
+
+	// text prefixes of the encoded keys
+	publicKeyPrefix = "D2V-PUBLIC-KEY-"
+	secretKeyPrefix = "D2V-SECRET-KEY-"
+)
+
+var (
+	// ErrNoMatchingIdentity is returned when none of the identities is a recipient of the video
+	ErrNoMatchingIdentity = errors.New("no identity matches the video recipients")
+
+	// ErrNoRecipientStanza is returned when the video is not encrypted for recipients
+	ErrNoRecipientStanza = errors.New("the video is not encrypted for recipients")
+)
+
+// Identity is an X25519 private key able to decrypt videos encrypted for its recipient
+type Identity struct {
+	key *ecdh.PrivateKey
+}
+
+// Recipient is an X25519 public key videos can be encrypted for
+type Recipient struct {
+	key *ecdh.PublicKey
+}
+
+// GenerateIdentity generates a random identity
+func GenerateIdentity() (Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return Identity{}, fmt.Errorf("failed to generate identity: %w", err)
+	}
+
+	return Identity{key: key}, nil
+}
+
+// Recipient returns the public key of the identity
+func (i Identity) Recipient() Recipient {
+	return Recipient{key: i.key.PublicKey()}
+}
+
+func (i Identity) String() string {
+	return keys.Encode(secretKeyPrefix, i.key.Bytes())
+}
+
+func (r Recipient) String() string {
+	return keys.Encode(publicKeyPrefix, r.key.Bytes())
+}
+
+// ParseIdentity decodes a D2V-SECRET-KEY- encoded identity
+func ParseIdentity(s string) (Identity, error) {
+	data, err := keys.Decode(s, secretKeyPrefix)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	key, err := ecdh.X25519().NewPrivateKey(data)
+	if err != nil {
+		return Identity{}, fmt.Errorf("invalid identity: %w", err)
+	}
+
+	return Identity{key: key}, nil
+}
+
+// ParseRecipient decodes a D2V-PUBLIC-KEY- encoded recipient
+func ParseRecipient(s string) (Recipient, error) {
+	data, err := keys.Decode(s, publicKeyPrefix)
+	if err != nil {
+		return Recipient{}, err
+	}
+
+	key, err := ecdh.X25519().NewPublicKey(data)
+	if err != nil {
+		return Recipient{}, fmt.Errorf("invalid recipient: %w", err)
+	}
+
+	return Recipient{key: key}, nil
+}
+
+// ParseIdentities reads the identities of an identity file: one per line, # comments ignored
+func ParseIdentities(data []byte) ([]Identity, error) {
+	return keys.Scan(data, ParseIdentity)
+}
+
+// ParseRecipients reads the recipients of a recipients file: one per line, # comments ignored
+func ParseRecipients(data []byte) ([]Recipient, error) {
+	return keys.Scan(data, ParseRecipient)
+}
+
+// RecipientStanza wraps the file key for a recipient: an ephemeral key agreement with the
+// recipient public key, HKDF-SHA256 of the shared secret keying the wrap
+func (k Key) RecipientStanza(r Recipient) (Stanza, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return Stanza{}, err
+	}
+
+	shared, err := ephemeral.ECDH(r.key)
+	if err != nil {
+		return Stanza{}, fmt.Errorf("invalid recipient: %w", err)
+	}
+
+	kek, err := recipientKEK(shared, ephemeral.PublicKey(), r.key)
+	if err != nil {
+		return Stanza{}, err
+	}
+
+	wrapped, err := wrap(kek, k.secret)
+	if err != nil {
+		return Stanza{}, err
+	}
+
+	return Stanza{Type: StanzaX25519, EphemeralKey: ephemeral.PublicKey().Bytes(), WrappedKey: wrapped}, nil
+}
+
+// UnlockIdentities returns the file key unwrapped from a recipient stanza matching one of the identities
+func (e Envelope) UnlockIdentities(identities []Identity) (Key, error) {
+	var found bool
+
+	for _, stanza := range e.Stanzas {
+		if stanza.Type != StanzaX25519 {
+			continue
+		}
+
+		found = true
+
+		ephemeral, err := ecdh.X25519().NewPublicKey(stanza.EphemeralKey)
+		if err != nil {
+			return Key{}, fmt.Errorf("invalid recipient stanza: %w", err)
+		}
+
+		// stanzas do not name their recipient => every identity is tried
+		for _, identity := range identities {
+			shared, err := identity.key.ECDH(ephemeral)
+			if err != nil {
+				continue
+			}
+
+			kek, err := recipientKEK(shared, ephemeral, identity.key.PublicKey())
+			if err != nil {
+				return Key{}, err
+			}
+
+			if secret, ok := unwrap(kek, stanza.WrappedKey); ok {
+				return Key{cipher: e.Cipher, secret: secret}, nil
+			}
+		}
+	}
+
+	if !found {
+		return Key{}, ErrNoRecipientStanza
+	}
+
+	return Key{}, ErrNoMatchingIdentity
+}
+
+// recipientKEK derives the key encryption key of a recipient stanza from the X25519 shared
+// secret, salted with the ephemeral and recipient public keys
+func recipientKEK(shared []byte, ephemeral, recipient *ecdh.PublicKey) ([]byte, error) {
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+
+	return hkdf.Key(sha256.New, shared, salt, "data2vid x25519", KeySize)
+}
@@ -0,0 +1,122 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// testIdentities generates count identities
+func testIdentities(t *testing.T, count int) []Identity {
+	t.Helper()
+
+	identities := make([]Identity, count)
+
+	for i := range identities {
+		var err error
+
+		if identities[i], err = GenerateIdentity(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return identities
+}
+
+func TestUnlockIdentities(t *testing.T) {
+	var (
+		key        = testKey(ChaCha20Poly1305, 1)
+		identities = testIdentities(t, 4)
+		stanzas    []Stanza
+	)
+
+	// the video is encrypted for the first three identities
+	for _, identity := range identities[:3] {
+		stanza, err := key.RecipientStanza(identity.Recipient())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stanzas = append(stanzas, stanza)
+	}
+
+	tampered := func(modify func(s *Stanza)) Envelope {
+		s := stanzas[0]
+		s.EphemeralKey, s.WrappedKey = bytes.Clone(s.EphemeralKey), bytes.Clone(s.WrappedKey)
+
+		modify(&s)
+
+		return Envelope{Cipher: ChaCha20Poly1305, Stanzas: []Stanza{s}}
+	}
+
+	envelope := Envelope{Cipher: ChaCha20Poly1305, Stanzas: stanzas}
+
+	tests := []struct {
+		name       string
+		envelope   Envelope
+		identities []Identity
+		err        error
+	}{
+		{"first recipient", envelope, identities[:1], nil},
+		{"last recipient", envelope, identities[2:3], nil},
+		{"several identities", envelope, []Identity{identities[3], identities[1]}, nil},
+		{"wrong identity", envelope, identities[3:], ErrNoMatchingIdentity},
+		{"no identity", envelope, nil, ErrNoMatchingIdentity},
+		{"passphrase only", Envelope{Cipher: ChaCha20Poly1305, Stanzas: []Stanza{{Type: StanzaScrypt}}}, identities, ErrNoRecipientStanza},
+		{"tampered wrapped key", tampered(func(s *Stanza) { s.WrappedKey[0] ^= 1 }), identities, ErrNoMatchingIdentity},
+		{"tampered ephemeral key", tampered(func(s *Stanza) { s.EphemeralKey[0] ^= 1 }), identities, ErrNoMatchingIdentity},
+		{"stanza of another recipient", tampered(func(s *Stanza) {
+			other, err := key.RecipientStanza(identities[3].Recipient())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s.EphemeralKey = other.EphemeralKey
+		}), identities, ErrNoMatchingIdentity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unlocked, err := tt.envelope.UnlockIdentities(tt.identities)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, expected %v", err, tt.err)
+			}
+
+			if err == nil && (unlocked.cipher != key.cipher || !bytes.Equal(unlocked.secret, key.secret)) {
+				t.Fatal("wrong file key unlocked")
+			}
+		})
+	}
+
+	if _, err := tampered(func(s *Stanza) { s.EphemeralKey = s.EphemeralKey[1:] }).UnlockIdentities(identities); err == nil {
+		t.Fatal("truncated ephemeral key accepted")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	identities := testIdentities(t, 2)
+
+	parsedIdentities, err := ParseIdentities([]byte("# identities\n\n" + identities[0].String() + "\n  " + identities[1].String() + "  \n"))
+	if err != nil || len(parsedIdentities) != 2 || parsedIdentities[1].String() != identities[1].String() {
+		t.Fatalf("identities not parsed: %v", err)
+	}
+
+	recipients, err := ParseRecipients([]byte(identities[0].Recipient().String() + "\n" + identities[1].Recipient().String()))
+	if err != nil || len(recipients) != 2 || recipients[0].String() != identities[0].Recipient().String() {
+		t.Fatalf("recipients not parsed: %v", err)
+	}
+
+	for _, invalid := range []string{
+		identities[0].Recipient().String(),
+		identities[0].String()[:20],
+		identities[0].String() + "!",
+	} {
+		if _, err = ParseIdentity(invalid); err == nil {
+			t.Errorf("identity %q accepted", invalid)
+		}
+	}
+
+	if _, err = ParseRecipients([]byte("# recipients\n" + identities[0].String())); err == nil {
+		t.Error("identity accepted as a recipient")
+	}
+}
@@ -0,0 +1,88 @@
+package fec
+
+import "fmt"
+
+// Cross-frame erasure coding: a group of data shards (frame payloads, zero padded to the same
+// length) is protected by `parity` shards. Byte i of every shard forms one Reed-Solomon
+// codeword (data shards first), so any `parity` missing shards of the group can be rebuilt.
+
+// EncodeShards returns the parity shards of a group of equally sized data shards
+func (c *Codec) EncodeShards(data [][]byte) ([][]byte, error) {
+	if len(data)+c.parity > MaxCodewordSize {
+		return nil, fmt.Errorf("too many shards in group (%d data + %d parity)", len(data), c.parity)
+	}
+
+	var (
+		size   = len(data[0])
+		parity = make([][]byte, c.parity)
+		column = make([]byte, len(data))
+	)
+
+	for j := range parity {
+		parity[j] = make([]byte, size)
+	}
+
+	for i := 0; i < size; i++ {
+		for j := range data {
+			column[j] = data[j][i]
+		}
+
+		for j, p := range c.Encode(column) {
+			parity[j][i] = p
+		}
+	}
+
+	return parity, nil
+}
+
+// ReconstructShards rebuilds the missing (nil) shards of a group in place.
+// shards holds the data shards followed by the parity shards.
+func (c *Codec) ReconstructShards(shards [][]byte) error {
+	var (
+		erasures []int
+		size     = -1
+	)
+
+	for j, shard := range shards {
+		if shard == nil {
+			erasures = append(erasures, j)
+			continue
+		}
+
+		if size != -1 && len(shard) != size {
+			return fmt.Errorf("shard %d size mismatch (%d bytes, expected %d)", j, len(shard), size)
+		}
+
+		size = len(shard)
+	}
+
+	if len(erasures) == 0 {
+		return nil
+	}
+
+	if len(erasures) > c.parity || size == -1 {
+		return fmt.Errorf("%d shards missing, at most %d can be rebuilt: %w", len(erasures), c.parity, ErrUncorrectable)
+	}
+
+	for _, j := range erasures {
+		shards[j] = make([]byte, size)
+	}
+
+	column := make([]byte, len(shards))
+
+	for i := 0; i < size; i++ {
+		for j := range shards {
+			column[j] = shards[j][i]
+		}
+
+		if _, err := c.Decode(column, erasures); err != nil {
+			return err
+		}
+
+		for _, j := range erasures {
+			shards[j][i] = column[j]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package fec
+
+import (
+	"bytes"
+	"errors"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestReconstructShards(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    int
+		parity  int
+		missing []int // shard indexes, parity shards after the data ones
+		ok      bool
+	}{
+		{"nothing missing", 10, 2, nil, true},
+		{"one data shard", 10, 2, []int{3}, true},
+		{"parity shards worth of data", 10, 3, []int{0, 5, 9}, true},
+		{"data and parity shards", 10, 3, []int{2, 11, 12}, true},
+		{"short group", 3, 2, []int{0, 2}, true},
+		{"single data shard", 1, 1, []int{0}, true},
+		{"too many missing", 10, 2, []int{1, 2, 3}, false},
+		{"every shard missing", 4, 2, []int{0, 1, 2, 3, 4, 5}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				c, _ = NewCodec(tt.parity)
+				r    = rand.New(rand.NewPCG(uint64(tt.data), uint64(tt.parity)))
+				data = make([][]byte, tt.data)
+			)
+
+			for i := range data {
+				data[i] = make([]byte, 1000)
+
+				for j := range data[i] {
+					data[i][j] = byte(r.Uint32())
+				}
+			}
+
+			parity, err := c.EncodeShards(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var (
+				original = append(append([][]byte(nil), data...), parity...)
+				shards   = append([][]byte(nil), original...)
+			)
+
+			for _, i := range tt.missing {
+				shards[i] = nil
+			}
+
+			err = c.ReconstructShards(shards)
+
+			if !tt.ok {
+				if !errors.Is(err, ErrUncorrectable) {
+					t.Fatalf("got %v, expected %v", err, ErrUncorrectable)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := range shards {
+				if !bytes.Equal(shards[i], original[i]) {
+					t.Fatalf("shard %d not restored", i)
+				}
+			}
+		})
+	}
+}
+
+func TestReconstructShardsSizeMismatch(t *testing.T) {
+	c, _ := NewCodec(2)
+
+	shards := [][]byte{make([]byte, 10), nil, make([]byte, 9), make([]byte, 10)}
+
+	if err := c.ReconstructShards(shards); err == nil {
+		t.Fatal("shards of different sizes accepted")
+	}
+}
+
+func TestEncodeShardsGroupSize(t *testing.T) {
+	c, _ := NewCodec(10)
+
+	if _, err := c.EncodeShards(make([][]byte, MaxCodewordSize-9)); err == nil {
+		t.Fatal("group larger than a codeword accepted")
+	}
+}
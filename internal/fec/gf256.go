@@ -0,0 +1,138 @@
+package fec
+
+// GF(2^8) arithmetic with the 0x11d primitive polynomial and generator 2
+
+var (
+	gfExp [512]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+
+	// doubled table avoids the modulo in gfMul
+	for i := 255; i < 512; i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+
+	return gfExp[(int(gfLog[a])+255-int(gfLog[b]))%255]
+}
+
+func gfPow(a byte, power int) byte {
+	if a == 0 {
+		return 0
+	}
+
+	e := (int(gfLog[a]) * power) % 255
+	if e < 0 {
+		e += 255
+	}
+
+	return gfExp[e]
+}
+
+func gfInverse(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// polynomials are stored highest degree first
+
+func polyScale(p []byte, x byte) []byte {
+	r := make([]byte, len(p))
+
+	for i, c := range p {
+		r[i] = gfMul(c, x)
+	}
+
+	return r
+}
+
+func polyAdd(p, q []byte) []byte {
+	r := make([]byte, max(len(p), len(q)))
+
+	for i, c := range p {
+		r[i+len(r)-len(p)] = c
+	}
+
+	for i, c := range q {
+		r[i+len(r)-len(q)] ^= c
+	}
+
+	return r
+}
+
+func polyMul(p, q []byte) []byte {
+	r := make([]byte, len(p)+len(q)-1)
+
+	for j, b := range q {
+		for i, a := range p {
+			r[i+j] ^= gfMul(a, b)
+		}
+	}
+
+	return r
+}
+
+func polyEval(p []byte, x byte) byte {
+	y := p[0]
+
+	for _, c := range p[1:] {
+		y = gfMul(y, x) ^ c
+	}
+
+	return y
+}
+
+// polyMod returns the remainder of the division of p by the monic divisor d
+func polyMod(p, d []byte) []byte {
+	out := append([]byte(nil), p...)
+
+	for i := 0; i < len(p)-len(d)+1; i++ {
+		coef := out[i]
+		if coef == 0 {
+			continue
+		}
+
+		for j := 1; j < len(d); j++ {
+			if d[j] != 0 {
+				out[i+j] ^= gfMul(d[j], coef)
+			}
+		}
+	}
+
+	return out[len(out)-(len(d)-1):]
+}
+
+func reversed(p []byte) []byte {
+	r := make([]byte, len(p))
+
+	for i, c := range p {
+		r[len(p)-1-i] = c
+	}
+
+	return r
+}
@@ -0,0 +1,82 @@
+package fec
+
+// A frame body is split into equal length codewords interleaved byte by byte:
+//
+//	body:  c0[0] c1[0] c2[0] ... cN[0] c0[1] c1[1] ... cN[len-1] (unused tail)
+//
+// so a burst of damaged cells hits many codewords once instead of one codeword many times.
+// Each codeword carries a contiguous slice of the data followed by its parity symbols.
+
+// Codewords returns the count and length of the codewords filling a body of `size` bytes
+func Codewords(size int) (int, int) {
+	count := (size + MaxCodewordSize - 1) / MaxCodewordSize
+	if count == 0 {
+		return 0, 0
+	}
+
+	return count, size / count
+}
+
+// DataSize returns the number of data bytes a body of `size` bytes carries once `parity`
+// symbols are added to each codeword
+func DataSize(size, parity int) int {
+	count, length := Codewords(size)
+	if length <= parity {
+		return 0
+	}
+
+	return count * (length - parity)
+}
+
+// EncodeInterleaved protects data (up to DataSize(size) bytes, zero padded) and returns a body of `size` bytes
+func (c *Codec) EncodeInterleaved(data []byte, size int) []byte {
+	var (
+		count, length = Codewords(size)
+		dataLen       = length - c.parity
+		body          = make([]byte, size)
+		codeword      = make([]byte, dataLen)
+	)
+
+	for k := 0; k < count; k++ {
+		clear(codeword)
+		copy(codeword, data[min(k*dataLen, len(data)):min((k+1)*dataLen, len(data))])
+
+		for i, b := range append(codeword, c.Encode(codeword)...) {
+			body[i*count+k] = b
+		}
+	}
+
+	return body
+}
+
+// DecodeInterleaved corrects the codewords of a body and returns the data with the number of
+// corrected symbols. Codewords with too many errors are returned as received, the frame
+// checksum decides whether the data is usable.
+func (c *Codec) DecodeInterleaved(body []byte) ([]byte, int) {
+	var (
+		count, length = Codewords(len(body))
+		dataLen       = length - c.parity
+		data          = make([]byte, 0, count*dataLen)
+		codeword      = make([]byte, length)
+		corrected     int
+	)
+
+	for k := 0; k < count; k++ {
+		for i := range codeword {
+			codeword[i] = body[i*count+k]
+		}
+
+		if n, err := c.Decode(codeword, nil); err == nil {
+			corrected += n
+		} else {
+			// restore the received symbols
+			for i := range codeword {
+				codeword[i] = body[i*count+k]
+			}
+		}
+
+		data = append(data, codeword[:dataLen]...)
+	}
+
+	return data, corrected
+}
@@ -0,0 +1,139 @@
+package fec
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestInterleaveRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		parity int
+		data   int // data bytes, -1 for DataSize
+	}{
+		{"single codeword", 255, 32, -1},
+		{"short body", 100, 8, -1},
+		{"several codewords", 1000, 16, -1},
+		{"frame body", 115200, 32, -1},
+		{"zero padded data", 1000, 16, 10},
+		{"empty data", 600, 2, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewCodec(tt.parity)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var (
+				r        = rand.New(rand.NewPCG(uint64(tt.size), uint64(tt.parity)))
+				capacity = DataSize(tt.size, tt.parity)
+				data     = make([]byte, capacity)
+			)
+
+			if tt.data >= 0 {
+				data = data[:tt.data]
+			}
+
+			for i := range data {
+				data[i] = byte(r.Uint32())
+			}
+
+			body := c.EncodeInterleaved(data, tt.size)
+
+			if len(body) != tt.size {
+				t.Fatalf("body of %d bytes, expected %d", len(body), tt.size)
+			}
+
+			decoded, corrected := c.DecodeInterleaved(body)
+
+			if len(decoded) != capacity || corrected != 0 {
+				t.Fatalf("%d bytes decoded with %d corrections, expected %d bytes", len(decoded), corrected, capacity)
+			}
+
+			if !bytes.Equal(decoded[:len(data)], data) || !bytes.Equal(decoded[len(data):], make([]byte, capacity-len(data))) {
+				t.Fatal("data not restored")
+			}
+		})
+	}
+}
+
+// a burst of parity/2 damaged bytes per codeword is spread over all of them
+func TestInterleaveCorrectsBursts(t *testing.T) {
+	var (
+		size, parity = 10000, 16
+		count, _     = Codewords(size)
+		c, _         = NewCodec(parity)
+		data         = make([]byte, DataSize(size, parity))
+		r            = rand.New(rand.NewPCG(1, 2))
+	)
+
+	for i := range data {
+		data[i] = byte(r.Uint32())
+	}
+
+	for _, start := range []int{0, 1234, size - count*parity/2} {
+		body := c.EncodeInterleaved(data, size)
+
+		for i := start; i < start+count*parity/2; i++ {
+			body[i] ^= 0xFF
+		}
+
+		decoded, corrected := c.DecodeInterleaved(body)
+
+		if !bytes.Equal(decoded, data) || corrected != count*parity/2 {
+			t.Fatalf("burst at %d: %d corrections, data restored: %v", start, corrected, bytes.Equal(decoded, data))
+		}
+	}
+}
+
+// codewords with too many errors are returned as received, the others are still corrected
+func TestInterleaveUncorrectableCodeword(t *testing.T) {
+	var (
+		size, parity  = 2000, 8
+		count, length = Codewords(size)
+		c, _          = NewCodec(parity)
+		data          = make([]byte, DataSize(size, parity))
+		r             = rand.New(rand.NewPCG(3, 4))
+	)
+
+	for i := range data {
+		data[i] = byte(r.Uint32())
+	}
+
+	body := c.EncodeInterleaved(data, size)
+
+	// parity errors in the data of codeword 0, one in codeword 1
+	for i := range parity {
+		body[i*count] ^= 0xFF
+	}
+
+	body[1] ^= 0xFF
+
+	decoded, corrected := c.DecodeInterleaved(body)
+
+	if corrected != 1 {
+		t.Fatalf("%d corrections, expected 1", corrected)
+	}
+
+	dataLen := length - parity
+
+	for i := range dataLen {
+		expected := data[i]
+
+		if i < parity {
+			expected ^= 0xFF
+		}
+
+		if decoded[i] != expected {
+			t.Fatalf("byte %d of the uncorrectable codeword changed", i)
+		}
+	}
+
+	if !bytes.Equal(decoded[dataLen:], data[dataLen:]) {
+		t.Fatal("correctable codewords not restored")
+	}
+}
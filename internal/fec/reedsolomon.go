@@ -0,0 +1,241 @@
+package fec
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxCodewordSize is the largest Reed-Solomon codeword over GF(2^8)
+const MaxCodewordSize = 255
+
+var ErrUncorrectable = errors.New("too many errors to correct")
+
+// Codec is a systematic Reed-Solomon codec over GF(2^8): each codeword is the data
+// followed by `parity` symbols and can correct up to parity/2 corrupted symbols,
+// or up to `parity` erased symbols when their positions are known
+type Codec struct {
+	parity    int
+	generator []byte
+}
+
+// NewCodec creates a codec adding `parity` symbols to every codeword
+func NewCodec(parity int) (*Codec, error) {
+	if parity <= 0 || parity >= MaxCodewordSize {
+		return nil, fmt.Errorf("invalid parity symbol count %d (expected 1 to %d)", parity, MaxCodewordSize-1)
+	}
+
+	generator := []byte{1}
+
+	for i := 0; i < parity; i++ {
+		generator = polyMul(generator, []byte{1, gfPow(2, i)})
+	}
+
+	return &Codec{parity: parity, generator: generator}, nil
+}
+
+// Parity returns the number of parity symbols of a codeword
+func (c *Codec) Parity() int {
+	return c.parity
+}
+
+// Encode returns the parity symbols of data (len(data)+parity must not exceed MaxCodewordSize)
+func (c *Codec) Encode(data []byte) []byte {
+	remainder := make([]byte, len(data)+c.parity)
+	copy(remainder, data)
+
+	for i := range data {
+		coef := remainder[i]
+		if coef == 0 {
+			continue
+		}
+
+		for j := 1; j < len(c.generator); j++ {
+			remainder[i+j] ^= gfMul(c.generator[j], coef)
+		}
+	}
+
+	return remainder[len(data):]
+}
+
+// Decode corrects the codeword (data followed by parity) in place. The positions of known
+// erased symbols can be given to double the correction capacity for them.
+// It returns the number of corrected symbols.
+func (c *Codec) Decode(codeword []byte, erasures []int) (int, error) {
+	if len(erasures) > c.parity {
+		return 0, ErrUncorrectable
+	}
+
+	for _, pos := range erasures {
+		codeword[pos] = 0
+	}
+
+	syndromes := c.syndromes(codeword)
+	if isZero(syndromes) {
+		return 0, nil
+	}
+
+	forney := forneySyndromes(syndromes, erasures, len(codeword))
+
+	locator, err := c.errorLocator(forney, len(erasures))
+	if err != nil {
+		return 0, err
+	}
+
+	positions, err := findErrors(reversed(locator), len(codeword))
+	if err != nil {
+		return 0, err
+	}
+
+	positions = append(append([]int(nil), erasures...), positions...)
+
+	if err = correctErrata(codeword, syndromes, positions); err != nil {
+		return 0, err
+	}
+
+	if !isZero(c.syndromes(codeword)) {
+		return 0, ErrUncorrectable
+	}
+
+	return len(positions), nil
+}
+
+// syndromes evaluates the codeword at the generator roots, with a leading 0 for Forney
+func (c *Codec) syndromes(codeword []byte) []byte {
+	syndromes := make([]byte, c.parity+1)
+
+	for i := 0; i < c.parity; i++ {
+		syndromes[i+1] = polyEval(codeword, gfPow(2, i))
+	}
+
+	return syndromes
+}
+
+// errorLocator runs Berlekamp-Massey on the Forney syndromes
+func (c *Codec) errorLocator(syndromes []byte, erasureCount int) ([]byte, error) {
+	var (
+		locator = []byte{1}
+		old     = []byte{1}
+		shift   = len(syndromes) - c.parity
+	)
+
+	if shift < 0 {
+		shift = 0
+	}
+
+	for i := 0; i < c.parity-erasureCount; i++ {
+		k := i + shift
+		delta := syndromes[k]
+
+		for j := 1; j < len(locator); j++ {
+			delta ^= gfMul(locator[len(locator)-1-j], syndromes[k-j])
+		}
+
+		old = append(old, 0)
+
+		if delta != 0 {
+			if len(old) > len(locator) {
+				next := polyScale(old, delta)
+				old = polyScale(locator, gfInverse(delta))
+				locator = next
+			}
+
+			locator = polyAdd(locator, polyScale(old, delta))
+		}
+	}
+
+	for len(locator) > 0 && locator[0] == 0 {
+		locator = locator[1:]
+	}
+
+	if errs := len(locator) - 1; errs*2+erasureCount > c.parity {
+		return nil, ErrUncorrectable
+	}
+
+	return locator, nil
+}
+
+// forneySyndromes removes the known erasures from the syndromes
+func forneySyndromes(syndromes []byte, erasures []int, length int) []byte {
+	forney := append([]byte(nil), syndromes[1:]...)
+
+	for _, pos := range erasures {
+		x := gfPow(2, length-1-pos)
+
+		for j := 0; j < len(forney)-1; j++ {
+			forney[j] = gfMul(forney[j], x) ^ forney[j+1]
+		}
+	}
+
+	return forney
+}
+
+// findErrors runs a Chien search for the roots of the error locator
+func findErrors(locator []byte, length int) ([]int, error) {
+	var positions []int
+
+	for i := 0; i < length; i++ {
+		if polyEval(locator, gfPow(2, i)) == 0 {
+			positions = append(positions, length-1-i)
+		}
+	}
+
+	if len(positions) != len(locator)-1 {
+		return nil, ErrUncorrectable
+	}
+
+	return positions, nil
+}
+
+// correctErrata computes the error magnitudes with the Forney algorithm and fixes the codeword
+func correctErrata(codeword, syndromes []byte, positions []int) error {
+	var (
+		locator = []byte{1}
+		x       = make([]byte, len(positions))
+	)
+
+	for i, pos := range positions {
+		coef := len(codeword) - 1 - pos
+
+		locator = polyMul(locator, polyAdd([]byte{1}, []byte{gfPow(2, coef), 0}))
+		x[i] = gfPow(2, coef)
+	}
+
+	// error evaluator: (syndromes * locator) mod x^(errata+1)
+	divisor := make([]byte, len(locator)+1)
+	divisor[0] = 1
+
+	evaluator := reversed(polyMod(polyMul(reversed(syndromes), locator), divisor))
+
+	for i, xi := range x {
+		var (
+			xiInv = gfInverse(xi)
+			prime = byte(1)
+		)
+
+		for j, xj := range x {
+			if j != i {
+				prime = gfMul(prime, 1^gfMul(xiInv, xj))
+			}
+		}
+
+		if prime == 0 {
+			return ErrUncorrectable
+		}
+
+		y := gfMul(xi, polyEval(reversed(evaluator), xiInv))
+
+		codeword[positions[i]] ^= gfDiv(y, prime)
+	}
+
+	return nil
+}
+
+func isZero(p []byte) bool {
+	for _, c := range p {
+		if c != 0 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,135 @@
+package fec
+
+import (
+	"bytes"
+	"errors"
+	"math/rand/v2"
+	"testing"
+)
+
+// codeword returns a random codeword of `size` data bytes followed by the parity of the codec
+func codeword(t *testing.T, c *Codec, r *rand.Rand, size int) []byte {
+	t.Helper()
+
+	data := make([]byte, size)
+
+	for i := range data {
+		data[i] = byte(r.Uint32())
+	}
+
+	return append(data, c.Encode(data)...)
+}
+
+// corrupt flips `count` distinct symbols of the codeword and returns their positions
+func corrupt(codeword []byte, r *rand.Rand, count int) []int {
+	positions := r.Perm(len(codeword))[:count]
+
+	for _, pos := range positions {
+		codeword[pos] ^= byte(1 + r.IntN(255))
+	}
+
+	return positions
+}
+
+func TestDecodeCorrectsUpToParity(t *testing.T) {
+	tests := []struct {
+		name     string
+		parity   int
+		size     int
+		errors   int
+		erasures int
+	}{
+		{"no errors", 8, 100, 0, 0},
+		{"single error", 2, 10, 1, 0},
+		{"errors only", 8, 100, 4, 0},
+		{"erasures only", 8, 100, 0, 8},
+		{"errors and erasures", 8, 100, 2, 4},
+		{"full codeword errors", 32, 223, 16, 0},
+		{"full codeword erasures", 32, 223, 0, 32},
+		{"full codeword errata", 32, 223, 10, 12},
+		{"short codeword", 16, 1, 8, 0},
+		{"large parity", 128, 127, 30, 68},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewCodec(tt.parity)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := rand.New(rand.NewPCG(uint64(tt.parity), uint64(tt.size)))
+
+			for trial := range 50 {
+				var (
+					original = codeword(t, c, r, tt.size)
+					received = bytes.Clone(original)
+					damaged  = corrupt(received, r, tt.errors+tt.erasures)
+				)
+
+				corrected, err := c.Decode(received, damaged[tt.errors:])
+				if err != nil {
+					t.Fatalf("trial %d: %v", trial, err)
+				}
+
+				if !bytes.Equal(received, original) {
+					t.Fatalf("trial %d: codeword not restored", trial)
+				}
+
+				if corrected != tt.errors+tt.erasures {
+					t.Fatalf("trial %d: %d symbols corrected, expected %d", trial, corrected, tt.errors+tt.erasures)
+				}
+			}
+		})
+	}
+}
+
+// Beyond the correction capacity a received word may lie within parity/2 symbols of another
+// codeword, with a probability of about 1/(parity/2)! for random errors: the parities below
+// are large enough for the trials to always be reported as uncorrectable.
+func TestDecodeReportsUncorrectable(t *testing.T) {
+	tests := []struct {
+		name     string
+		parity   int
+		size     int
+		errors   int
+		erasures int
+	}{
+		{"one error too many", 16, 200, 9, 0},
+		{"twice the capacity", 16, 200, 16, 0},
+		{"errors and erasures", 32, 200, 9, 15},
+		{"full codeword errors", 32, 223, 17, 0},
+		{"too many erasures", 8, 100, 0, 9},
+		{"mostly erasures", 32, 100, 7, 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewCodec(tt.parity)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := rand.New(rand.NewPCG(uint64(tt.errors), uint64(tt.erasures)))
+
+			for trial := range 50 {
+				var (
+					received = codeword(t, c, r, tt.size)
+					damaged  = corrupt(received, r, tt.errors+tt.erasures)
+				)
+
+				if _, err := c.Decode(received, damaged[tt.errors:]); !errors.Is(err, ErrUncorrectable) {
+					t.Fatalf("trial %d: got %v, expected %v", trial, err, ErrUncorrectable)
+				}
+			}
+		})
+	}
+}
+
+func TestNewCodecParity(t *testing.T) {
+	for _, parity := range []int{-1, 0, MaxCodewordSize} {
+		if _, err := NewCodec(parity); err == nil {
+			t.Errorf("parity %d accepted", parity)
+		}
+	}
+}
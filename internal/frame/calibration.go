@@ -0,0 +1,249 @@
+package frame
+
+import (
+	"math"
+	"slices"
+
+	"github.com/sabouaram/data2vid/internal/constants"
+)
+
+// Transcoders may squeeze the levels of a frame into the TV range (16-235), change their gamma
+// or invert them, so the decoder learns the levels of every frame instead of slicing fixed ones:
+//
+//   - the header copies are read with the Otsu threshold of the frame histogram, in both polarities
+//   - the black and white levels of the header cells then give a linear mapping of the gray levels
+//   - the calibration patches give the measured level of CalibrationLevels evenly
+//     spaced gray levels, the median of the patches being used
+//
+// and every sampled level is decoded as the symbol with the nearest expected level.
+//
+// calibration patch (CalibrationCellsPerLevel cells per level) after every header copy:
+//
+// +-----+-----+-----+-----+-----+-----+-----+-----+-----+-----+-----+
+// | 255 | 255 | 255 | 255 | 238 | 238 | 238 | 238 | ... |   0 |   0 |
+// +-----+-----+-----+-----+-----+-----+-----+-----+-----+-----+-----+
+
+// symbolTable maps the sampled 8 bit level of every color channel to a symbol
+type symbolTable [3][256]byte
+
+// calibrationLevel returns the gray intensity of the calibration level k, from white (0) to black
+func calibrationLevel(k int) uint8 {
+	return uint8(255 - k*255/(constants.CalibrationLevels-1))
+}
+
+// otsuThreshold returns the gray level splitting the histogram of a frame into the two classes
+// of largest between-class variance (Otsu's method), levels below it being the dark class.
+// The middle of the best range is used as histograms of clean frames leave a gap between classes.
+func otsuThreshold(p plane) uint8 {
+	var (
+		histogram = p.histogram()
+		total     float64
+		sum       float64
+		darkCount float64
+		darkSum   float64
+		best      = -1.0
+		first     = 128
+		last      = 128
+	)
+
+	for level, count := range histogram {
+		total += float64(count)
+		sum += float64(level) * float64(count)
+	}
+
+	for threshold := 1; threshold < 256; threshold++ {
+		darkCount += float64(histogram[threshold-1])
+		darkSum += float64(threshold-1) * float64(histogram[threshold-1])
+
+		if darkCount == 0 || darkCount == total {
+			continue
+		}
+
+		var (
+			darkMean   = darkSum / darkCount
+			brightMean = (sum - darkSum) / (total - darkCount)
+			variance   = darkCount * (total - darkCount) * (darkMean - brightMean) * (darkMean - brightMean)
+		)
+
+		switch {
+		case variance > best:
+			best, first, last = variance, threshold, threshold
+		case variance == best && last == threshold-1:
+			last = threshold
+		}
+	}
+
+	return uint8((first + last) / 2)
+}
+
+// binarySymbols returns the 1 bit symbols of a frame read with a threshold: levels below it are
+// black (1), or white for frames with inverted polarity
+func binarySymbols(threshold uint8, inverted bool) *symbolTable {
+	var table symbolTable
+
+	for level := range 256 {
+		if (level < int(threshold)) != inverted {
+			table[0][level], table[1][level], table[2][level] = 1, 1, 1
+		}
+	}
+
+	return &table
+}
+
+// calibrate returns the symbol tables of the body of a frame from the levels of its header cells
+// read with the binary symbols of its header, refined by its calibration patches when they are
+// consistent with them (a damaged patch is ignored)
+func calibrate(p plane, header Header, binary *symbolTable) *symbolTable {
+	var (
+		layout     = header.Layout
+		channels   = layout.ColorMode.Channels()
+		headerBits = header.Marshal()
+		starts     = layout.headerCells()
+		sums       [2][3]float64
+		counts     [2]float64
+		anchors    [constants.CalibrationLevels][3]float64
+	)
+
+	// white (0) and black (1) header cells, the ones not read as written are damaged
+	for _, start := range starts {
+		for bit := range constants.HeaderSize * 8 {
+			var (
+				rect  = layout.sampleRect(start + bit)
+				value = (headerBits[bit/8] >> (7 - bit%8)) & 1
+			)
+
+			if binary[0][p.gray(rect)] != value {
+				continue
+			}
+
+			levels := p.sample(rect, channels)
+
+			for c := range channels {
+				sums[value][c] += float64(levels[c])
+			}
+
+			counts[value]++
+		}
+	}
+
+	// linear mapping between the white and black levels
+	for k := range anchors {
+		for c := range channels {
+			white, black := sums[0][c]/max(counts[0], 1), sums[1][c]/max(counts[1], 1)
+
+			anchors[k][c] = white + (black-white)*float64(k)/float64(constants.CalibrationLevels-1)
+		}
+	}
+
+	if patch, ok := measurePatches(p, layout, anchors); ok {
+		anchors = patch
+	}
+
+	return nearestSymbols(&anchors, layout.BitsPerPixel, channels)
+}
+
+// measurePatches returns the levels of the calibration patches of a frame, median of the copies,
+// when their contrast agrees with the header cells levels in every channel
+func measurePatches(p plane, layout Layout, header [constants.CalibrationLevels][3]float64) ([constants.CalibrationLevels][3]float64, bool) {
+	var (
+		channels = layout.ColorMode.Channels()
+		starts   = layout.headerCells()
+		levels   = make([]float64, len(starts))
+		patch    [constants.CalibrationLevels][3]float64
+	)
+
+	for k := range patch {
+		for c := range channels {
+			for i, start := range starts {
+				var (
+					first = start + constants.HeaderSize*8 + k*constants.CalibrationCellsPerLevel
+					sum   float64
+				)
+
+				for cell := first; cell < first+constants.CalibrationCellsPerLevel; cell++ {
+					sum += float64(p.sample(layout.sampleRect(cell), channels)[c])
+				}
+
+				levels[i] = sum / constants.CalibrationCellsPerLevel
+			}
+
+			patch[k][c] = median(levels)
+		}
+	}
+
+	// white to black contrast: same polarity and at least half of the header one
+	for c := range channels {
+		var (
+			contrast       = patch[0][c] - patch[constants.CalibrationLevels-1][c]
+			headerContrast = header[0][c] - header[constants.CalibrationLevels-1][c]
+		)
+
+		if contrast*headerContrast <= 0 || math.Abs(contrast) < math.Abs(headerContrast)/2 {
+			return patch, false
+		}
+	}
+
+	return patch, true
+}
+
+// nearestSymbols returns the symbol tables mapping every sampled level to the symbol of `bits` bits
+// with the nearest expected level, interpolated between the calibration levels
+func nearestSymbols(anchors *[constants.CalibrationLevels][3]float64, bits, channels int) *symbolTable {
+	var (
+		table    symbolTable
+		count    = 1 << bits
+		expected = make([]float64, count)
+	)
+
+	for c := range channels {
+		// symbol levels from white to black
+		for level := range count {
+			var (
+				position = float64(level*(constants.CalibrationLevels-1)) / float64(count-1)
+				k        = min(int(position), constants.CalibrationLevels-2)
+				weight   = position - float64(k)
+			)
+
+			expected[level] = anchors[k][c]*(1-weight) + anchors[k+1][c]*weight
+		}
+
+		for sample := range 256 {
+			nearest := 0
+
+			for level := 1; level < count; level++ {
+				if math.Abs(float64(sample)-expected[level]) < math.Abs(float64(sample)-expected[nearest]) {
+					nearest = level
+				}
+			}
+
+			table[c][sample] = grayEncode(byte(nearest))
+		}
+	}
+
+	return &table
+}
+
+// median returns the median of values, sorting them
+func median(values []float64) float64 {
+	slices.Sort(values)
+
+	if len(values)%2 == 0 {
+		return (values[len(values)/2-1] + values[len(values)/2]) / 2
+	}
+
+	return values[len(values)/2]
+}
+
+// cellLevel returns the level painted on every channel of the cell at `bit` of a header copy:
+// the header bit (black or white), then the calibration patch
+func cellLevel(headerBits []byte, bit int) uint8 {
+	if bit < len(headerBits)*8 {
+		if headerBits[bit/8]&(0x80>>(bit%8)) != 0 {
+			return 0
+		}
+
+		return 0xFF
+	}
+
+	return calibrationLevel((bit - len(headerBits)*8) / constants.CalibrationCellsPerLevel)
+}
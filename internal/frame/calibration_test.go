@@ -0,0 +1,187 @@
+package frame
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/sabouaram/data2vid/internal/constants"
+)
+
+// renderImage renders a frame carrying data with the layout
+func renderImage(t *testing.T, layout Layout, data []byte) image.Image {
+	t.Helper()
+
+	pixels, err := renderPixels(nil, testHeader(layout, len(data)), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return RawImage(pixels, layout.Width, layout.Height, layout.ColorMode.RawPixelFormat())
+}
+
+// mapLevels returns a copy of a gray or RGBA frame with every channel level mapped by f, plus
+// gaussian noise of the given standard deviation
+func mapLevels(img image.Image, f func(float64) float64, noise float64, r *rand.Rand) image.Image {
+	level := func(v uint8) uint8 {
+		return uint8(max(0, min(255, math.Round(f(float64(v))+r.NormFloat64()*noise))))
+	}
+
+	switch img := img.(type) {
+	case *image.Gray:
+		out := image.NewGray(img.Rect)
+
+		for i, v := range img.Pix {
+			out.Pix[i] = level(v)
+		}
+
+		return out
+	case *image.RGBA:
+		out := image.NewRGBA(img.Rect)
+
+		for i, v := range img.Pix {
+			if out.Pix[i] = v; i%4 != 3 {
+				out.Pix[i] = level(v)
+			}
+		}
+
+		return out
+	}
+
+	return img
+}
+
+func TestProcessFrameLevelShifts(t *testing.T) {
+	var (
+		layouts = []struct {
+			name   string
+			layout Layout
+			noise  float64
+
+			// every level is a symbol: only shifts keeping the levels distinct can be decoded
+			exact bool
+		}{
+			{"gray-1bit", Layout{Width: 320, Height: 240, BitsPerPixel: 1, BlockSize: 1, ColorMode: ColorGray}, 0, false},
+			{"gray-2bits-noise", Layout{Width: 320, Height: 240, BitsPerPixel: 2, BlockSize: 2, ColorMode: ColorGray}, 3, false},
+			{"gray-4bits", Layout{Width: 320, Height: 240, BitsPerPixel: 4, BlockSize: 2, ColorMode: ColorGray}, 0, false},
+			{"gray-8bits", Layout{Width: 320, Height: 240, BitsPerPixel: 8, BlockSize: 1, ColorMode: ColorGray}, 0, true},
+			{"rgb-1bit-noise", Layout{Width: 320, Height: 240, BitsPerPixel: 1, BlockSize: 2, ColorMode: ColorRGB}, 5, false},
+			{"rgb-2bits-noise", Layout{Width: 320, Height: 240, BitsPerPixel: 2, BlockSize: 4, ColorMode: ColorRGB}, 3, false},
+		}
+
+		// level changes made by transcoders and players
+		shifts = []struct {
+			name  string
+			f     func(float64) float64
+			exact bool
+		}{
+			{"unchanged", func(v float64) float64 { return v }, true},
+			{"tv range", func(v float64) float64 { return 16 + v*219/255 }, false},
+			{"gain", func(v float64) float64 { return v * 0.4 }, false},
+			{"offset", func(v float64) float64 { return 150 + v*105/255 }, false},
+			{"gamma 2.2", func(v float64) float64 { return 255 * math.Pow(v/255, 2.2) }, false},
+			{"gamma 0.45", func(v float64) float64 { return 255 * math.Pow(v/255, 0.45) }, false},
+			{"tv range and gamma", func(v float64) float64 { return 16 + 219*math.Pow(v/255, 1.8) }, false},
+			{"inverted", func(v float64) float64 { return 255 - v }, true},
+			{"inverted tv range", func(v float64) float64 { return 235 - v*219/255 }, false},
+		}
+	)
+
+	for _, l := range layouts {
+		var (
+			data = testPayload(l.layout.PayloadSize())
+			img  = renderImage(t, l.layout, data)
+			r    = rand.New(rand.NewPCG(1, 2))
+		)
+
+		for _, shift := range shifts {
+			if l.exact && !shift.exact {
+				continue
+			}
+
+			t.Run(l.name+"/"+shift.name, func(t *testing.T) {
+				frame, err := ProcessFrame(mapLevels(img, shift.f, l.noise, r))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !bytes.Equal(frame.Payload, data) {
+					t.Fatal("payload not decoded")
+				}
+			})
+		}
+	}
+}
+
+func TestOtsuThreshold(t *testing.T) {
+	tests := []struct {
+		name        string
+		dark, light uint8
+		darkShare   float64
+		noise       float64
+	}{
+		{"black and white", 0, 255, 0.5, 0},
+		{"tv range", 16, 235, 0.5, 0},
+		{"mostly white", 0, 255, 0.1, 0},
+		{"mostly black", 0, 255, 0.9, 0},
+		{"low contrast", 100, 140, 0.3, 0},
+		{"noisy", 40, 200, 0.5, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				img = image.NewGray(image.Rect(0, 0, 100, 100))
+				r   = rand.New(rand.NewPCG(3, 4))
+			)
+
+			for i := range img.Pix {
+				level := tt.light
+
+				if float64(i) < tt.darkShare*float64(len(img.Pix)) {
+					level = tt.dark
+				}
+
+				img.Pix[i] = uint8(max(0, min(255, math.Round(float64(level)+r.NormFloat64()*tt.noise))))
+			}
+
+			if threshold := otsuThreshold(newPlane(img)); threshold <= tt.dark || threshold > tt.light {
+				t.Fatalf("threshold %d not between %d and %d", threshold, tt.dark, tt.light)
+			}
+		})
+	}
+}
+
+// damaged patches are outvoted by the other copies, or ignored for the header cells levels
+func TestProcessFrameDamagedPatches(t *testing.T) {
+	var (
+		layout = Layout{Width: 320, Height: 240, BitsPerPixel: 2, BlockSize: 2, ColorMode: ColorGray}
+		data   = testPayload(layout.PayloadSize())
+		img    = renderImage(t, layout, data)
+		starts = layout.headerCells()
+		r      = rand.New(rand.NewPCG(5, 6))
+	)
+
+	for _, damaged := range [][]int{starts[:1], starts} {
+		gray := mapLevels(img, func(v float64) float64 { return 16 + v*219/255 }, 2, r).(*image.Gray)
+
+		// patch cells painted mid gray
+		for _, start := range damaged {
+			for cell := start + constants.HeaderSize*8; cell < start+layout.headerSpan(); cell++ {
+				rect := layout.cellRect(cell)
+
+				for y := rect.Min.Y; y < rect.Max.Y; y++ {
+					for x := rect.Min.X; x < rect.Max.X; x++ {
+						gray.Pix[y*gray.Stride+x] = 128
+					}
+				}
+			}
+		}
+
+		if frame, err := ProcessFrame(gray); err != nil || !bytes.Equal(frame.Payload, data) {
+			t.Fatalf("%d damaged patches: payload not decoded (%v)", len(damaged), err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Package frame maps data streams onto video frames and back.
+//
+// 1. Cells:
+//
+// A frame is split into square cells of BlockSize x BlockSize pixels (BlockSize > 1 keeps
+// symbols readable after lossy re-encoding, the decoder averaging the centre of every cell).
+// Cells are filled row by row, left to right, top to bottom, skipping the finder patterns of
+// the corners. Pixels left over on the right and bottom edges stay white:
+//
+//	+-----+-------------------------------+-----+
+//	|  F  | 0  1  2  ...                  |  F  |
+//	|     | ...                           |     |
+//	+-----+                               +-----+
+//	| ...                                       |
+//	+-----+                               +-----+
+//	|  F  | ...                       n-1 |  F  |
+//	+-----+-------------------------------+-----+
+//
+// 2. Finder patterns:
+//
+// Every corner holds a FinderSize x FinderSize (9x9) cells square: a 7x7 cells pattern (3x3 black
+// centre, white ring, black ring) within a white border of one cell. The decoder locates them in
+// frames whose header is not found at their size (scaled, cropped or letterboxed by a platform)
+// and resamples the frame to its encoded size (see resampleFrame).
+//
+// 3. Header:
+//
+// The HeaderSize (64) bytes header describes the frame (see Header): the decoder needs no
+// configuration. It is written one bit per cell (0 -> white, 1 -> black) whatever the modulation,
+// and the decoder finds the block size from the magic string before reading the rest of it.
+//
+// 4. Header copies and calibration patches:
+//
+// HeaderCopies (3) copies of the header are written at the top, at the first cell of the middle
+// cell row and at the last cells of the frame, each followed by a calibration patch painting
+// CalibrationLevels (16) evenly spaced gray levels from white to black, CalibrationCellsPerLevel
+// (4) cells each:
+//
+//	+---------------------------+
+//	| Header, Patch             |  top copy
+//	| Payload ...               |
+//	| Header, Patch             |  middle copy
+//	| Payload ...               |
+//	|             Header, Patch |  bottom copy
+//	+---------------------------+
+//
+// The decoder uses the first copy that validates, or the bitwise majority vote of the three, reads
+// the headers with the Otsu threshold of the frame histogram in both polarities, then slices the
+// payload levels against the levels measured on the patches (see calibrate), so TV range
+// levels, gamma changes and inverted frames are decoded.
+//
+// 5. Payload modulation:
+//
+// The other cells carry the body: BitsPerPixel (1, 2, 4 or 8) bits per cell as one of 2, 4, 16 or
+// 256 evenly spaced Gray-coded gray levels (see grayLevels), and one such symbol in each of
+// the R, G and B channels in rgb ColorMode:
+//
+//	BitsPerPixel = 2 -> 11 10 01 00 ...  symbols from data
+//	                     ↓  ↓  ↓  ↓
+//	                    85  0 170 255 ...  gray levels
+//
+// A 1280x720 frame carries 114,943 body bytes at 1 bit per cell (921,600 cells - 4 x 81 finder
+// cells - 3 x (512 + 64) header and patch cells), 919,548 at 8 bits per cell, 344,830 in rgb
+// at 1 bit per channel and 28,543 with 2x2 blocks at 1 bit per cell.
+//
+// 6. Forward error correction (Parity > 0):
+//
+// The body is split into equal length Reed-Solomon codewords (up to 255 bytes, Parity of them
+// parity) interleaved byte by byte, so that up to Parity/2 damaged bytes per codeword are
+// corrected before the payload checksum check:
+//
+//	+-------+-------+-------+-----+-------+-------+-----+
+//	| c0[0] | c1[0] | c2[0] | ... | c0[1] | c1[1] | ... |
+//	+-------+-------+-------+-----+-------+-------+-----+
+//
+// 7. Streams:
+//
+// Every stream of a video is split over frames of its own kind, with their own sequence numbers
+// and total size: the key frames of encrypted videos (JSON envelope), the manifest frames, the
+// table of contents frames of archives, the data frames and the signature frames (never
+// encrypted, checked before any decryption):
+//
+//	+------+-----+------+-----+------+-----+------+-----+------+-----+
+//	|  K0  | ... |  M0  | ... |  T0  | ... |  D0  | ... |  S0  | ... |
+//	+------+-----+------+-----+------+-----+------+-----+------+-----+
+//
+// The data stream may be compressed and the streams following the key frames encrypted in
+// authenticated chunks, as recorded by the header compression and flags.
+//
+// 8. Parity frames (ParityFrames > 0):
+//
+// Every group of GroupSize data frames is followed by ParityFrames parity frames, byte i of all
+// the group payloads forming one Reed-Solomon codeword, so that up to ParityFrames missing or
+// unreadable frames of a group are rebuilt:
+//
+//	+------+------+-----+------+------+-----+------+------+-----+
+//	|  D0  |  D1  | ... |  Dn  |  P0  | ... |  Pk  | Dn+1 | ... |
+//	+------+------+-----+------+------+-----+------+------+-----+
+//
+// 9. Legacy frames:
+//
+// Black and white frames of the YTDSv3 format (a 32 bytes header followed by the payload, one
+// pixel per bit) are still decoded (see processLegacyFrame).
+package frame
@@ -1,40 +1,40 @@
 package frame
 
 import (
-	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"image"
-	"image/color"
-	"image/png"
 	"io"
-	"os"
-	"path/filepath"
-	"sync"
+	"slices"
 
-	"github.com/sabouaram/data2vid/internal/checksum"
 	"github.com/sabouaram/data2vid/internal/constants"
+	"github.com/sabouaram/data2vid/internal/fec"
+	"github.com/sabouaram/data2vid/internal/types"
 )
 
-var fileMutex sync.Mutex
-
-// CreateFrames generates PNG frames from input file data
-func CreateFrames(tempDir string, input io.Reader, fileSize int64, frameWidth, frameHeight int) ([]string, error) {
+// CreateFrames renders the data frames of the input into the sink as raw pixels, followed by
+// ParityFrames parity frames after every group of GroupSize data frames when cross-frame erasure
+// coding is enabled.
+// The header template holds the layout, the total size and the compression of the data, the
+// frames are appended to the ones already in the sink.
+func CreateFrames(sink *Sink, input io.Reader, header Header) error {
 
 	var (
-		framePaths []string
-		chunk      = make([]byte, constants.MaxPayloadPerFrame)
-		sequence   = 0
-		n          int
-		err        error
-		framePath  string
+		layout   = header.Layout
+		chunk    = make([]byte, layout.PayloadSize())
+		sequence = 0
+		n        int
+		err      error
+		group    [][]byte
 	)
 
+	header.Kind = types.KindData
+	header.TotalFrames = uint32((header.TotalSize + uint64(len(chunk)) - 1) / uint64(len(chunk)))
+
 	for {
 
 		if n, err = io.ReadFull(input, chunk); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
-			return nil, fmt.Errorf("read error: %w", err)
+			return fmt.Errorf("read error: %w", err)
 		}
 
 		if n == 0 {
@@ -42,235 +42,514 @@ func CreateFrames(tempDir string, input io.Reader, fileSize int64, frameWidth, f
 		}
 
 		// create frame for this chunk
-		framePath = filepath.Join(tempDir, fmt.Sprintf("frame_%04d.png", sequence))
+		header.Sequence = uint32(sequence)
 
-		if err = CreateSingleFrame(chunk[:n], sequence, fileSize, framePath, frameWidth, frameHeight); err != nil {
-			return nil, fmt.Errorf("frame creation failed: %w", err)
+		if err = appendFrame(sink, header, chunk[:n]); err != nil {
+			return err
 		}
 
-		framePaths = append(framePaths, framePath)
+		// keep the group payloads for its parity frames
+		if layout.ParityFrames > 0 {
+			group = append(group, append([]byte(nil), chunk[:n]...))
+
+			if len(group) == layout.GroupSize {
+				if err = appendParityFrames(sink, header, group, sequence/layout.GroupSize); err != nil {
+					return err
+				}
+
+				group = nil
+			}
+		}
 
 		sequence++
 	}
 
-	return framePaths, nil
-}
+	// last (partial) group
+	if len(group) > 0 {
+		return appendParityFrames(sink, header, group, sequence/layout.GroupSize)
+	}
 
-// CreateSingleFrame creates a single PNG frame from data
-func CreateSingleFrame(data []byte, sequence int, totalSize int64, outputPath string, frameWidth, frameHeight int) error {
+	return nil
+}
 
+// CreateMetadataFrames appends the frames carrying a metadata stream (such as the manifest):
+// the data is split over as many frames of the header template kind as needed, in sequence order
+func CreateMetadataFrames(sink *Sink, data []byte, header Header) error {
 	var (
-		outFile *os.File
-		err     error
-		bitPos  = 0
-		img     *image.Gray
+		err       error
+		chunkSize = header.Layout.PayloadSize()
 	)
 
-	// header
-	// +-------------+-------------+-------------+-------------+-------------+------------+
-	// | Magic String|  Total Size | Sequence #  | Chunk Size  |    Data     |  Header    |
-	// | (6 bytes)   |  (8 bytes)  | (4 bytes)   | (4 bytes)   |  Checksum   |  Checksum  |
-	// |             |             |             |             |  (8 bytes)  |  (2 bytes) |
-	// +-------------+-------------+-------------+-------------+-------------+-------------+
-	// | 0     5     | 6        13 | 14      17  | 18      21  | 22       29 | 30      31  |
-	// +-------------+-------------+-------------+-------------+-------------+-------------+
-	header := make([]byte, constants.HeaderSize)
-	copy(header[:6], []byte(constants.MagicString))
-	binary.BigEndian.PutUint64(header[6:14], uint64(totalSize))     // Total file size
-	binary.BigEndian.PutUint32(header[14:18], uint32(sequence))     // Sequence number
-	binary.BigEndian.PutUint32(header[18:22], uint32(len(data)))    // Chunk size
-	binary.BigEndian.PutUint64(header[22:30], checksum.CRC64(data)) // Data checksum
-	copy(header[30:32], checksum.ComputeChecksum(header[:30])[:2])  // Header checksum
-
-	frameData := append(header, data...)
-
-	// gray img
-	img = image.NewGray(image.Rect(0, 0, frameWidth, frameHeight))
+	header.TotalSize = uint64(len(data))
+	header.TotalFrames = uint32((len(data) + chunkSize - 1) / chunkSize)
 
-	// default white
-	for y := 0; y < frameHeight; y++ {
-		for x := 0; x < frameWidth; x++ {
-			img.Set(x, y, color.White)
+	for sequence := 0; sequence*chunkSize < len(data); sequence++ {
+		header.Sequence = uint32(sequence)
+
+		if err = appendFrame(sink, header, data[sequence*chunkSize:min((sequence+1)*chunkSize, len(data))]); err != nil {
+			return err
 		}
 	}
 
-	// setting pixels
-	for _, b := range frameData {
-		for bit := 7; bit >= 0; bit-- {
-			//  x,y position from bit position
-			x := (bitPos % frameWidth)
-			y := (bitPos / frameWidth)
-
-			if y >= frameHeight {
-				//  entire frame done
-				break
-			}
+	return nil
+}
 
-			// 1 -> black - 0 -> white
-			if (b & (1 << bit)) != 0 {
-				img.Set(x, y, color.Black)
-			}
+// appendParityFrames creates the parity frames of a group of data payloads,
+// parity frame j of group g carries sequence number g*ParityFrames + j
+func appendParityFrames(sink *Sink, header Header, group [][]byte, groupIndex int) error {
+	var (
+		codec  *fec.Codec
+		parity [][]byte
+		err    error
+		size   = len(group[0])
+		layout = header.Layout
+	)
 
-			bitPos++
+	// only the last payload of the file can be shorter => zero pad it
+	for i := range group {
+		if len(group[i]) < size {
+			group[i] = append(group[i], make([]byte, size-len(group[i]))...)
 		}
 	}
 
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
+	if codec, err = fec.NewCodec(layout.ParityFrames); err != nil {
+		return fmt.Errorf("fec error: %w", err)
+	}
 
-	// Save as PNG
-	if outFile, err = os.Create(outputPath); err != nil {
-		return fmt.Errorf("create file error: %w", err)
+	if parity, err = codec.EncodeShards(group); err != nil {
+		return fmt.Errorf("parity frames error: %w", err)
 	}
 
-	defer outFile.Close()
+	header.Kind = types.KindParity
+
+	for j, shard := range parity {
+		header.Sequence = uint32(groupIndex*layout.ParityFrames + j)
 
-	if err = png.Encode(outFile, img); err != nil {
-		return fmt.Errorf("png encode error: %w", err)
+		if err = appendFrame(sink, header, shard); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// ProcessFrameWithSequence extracts data from a frame and returns the payload - total size and sequence number
-func ProcessFrameWithSequence(framePath string, frameWidth, frameHeight int) ([]byte, uint64, int, error) {
+// renderPixels renders a single frame straight into its raw pixels, dst is reused when it is large
+// enough. Cells are painted on the first pixel line of their cell row, which is then copied to the
+// other lines of the row.
+func renderPixels(dst []byte, header Header, data []byte) ([]byte, error) {
 
 	var (
-		file          *os.File
-		err           error
-		img           image.Image
-		frameData     bytes.Buffer
-		currentByte   byte = 0
-		data, payload []byte
-		bitCount      = 0
+		err         error
+		layout      = header.Layout
+		blockSize   = layout.BlockSize
+		channels    = layout.ColorMode.Channels()
+		columns     = layout.Columns()
+		rows        = layout.Rows()
+		stride      = layout.Width * channels
+		size        = stride * layout.Height
+		body        = data
+		levels      = grayLevels(layout.BitsPerPixel)
+		headerBits  []byte
+		headerCells []int
+		span        = layout.headerSpan()
+		symbols     int
+		cells       int
+		codec       *fec.Codec
 	)
 
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
+	header.ChunkSize = uint32(len(data))
+	header.Checksum = header.ChecksumAlgorithm.Sum(data)
 
-	if file, err = os.Open(framePath); err != nil {
-		return nil, 0, 0, fmt.Errorf("failed to open frame: %w", err)
-	}
+	// Reed-Solomon codewords interleaved over the whole frame body
+	if layout.Parity > 0 {
+		if codec, err = fec.NewCodec(layout.Parity); err != nil {
+			return nil, fmt.Errorf("fec error: %w", err)
+		}
 
-	defer file.Close()
+		body = codec.EncodeInterleaved(data, layout.BodySize())
+	}
 
-	if img, _, err = image.Decode(file); err != nil {
-		return nil, 0, 0, fmt.Errorf("image decode failed: %w", err)
+	if cap(dst) < size {
+		dst = make([]byte, size)
 	}
 
-	//  dimensions verif
-	if img.Bounds().Dx() != frameWidth || img.Bounds().Dy() != frameHeight {
-		return nil, 0, 0, fmt.Errorf("invalid dimensions (%dx%d)", img.Bounds().Dx(), img.Bounds().Dy())
+	dst = dst[:size]
+
+	// default white
+	dst[0] = 0xFF
+
+	for i := 1; i < size; i *= 2 {
+		copy(dst[i:], dst[:i])
 	}
 
-	// extract binary data from black/white pixels
-	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
-		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
-			// grayscale value
-			r, g, b, _ := img.At(x, y).RGBA()
+	headerBits = header.Marshal()
+	headerCells = layout.headerCells()
+	symbols = len(body) * 8 / layout.BitsPerPixel
+	cells = columns * rows
+
+	// index: position of the cell around the finder patterns (see Layout.cellPosition)
+	for cell, index, symbol, next := 0, 0, 0, 0; cell < cells; cell++ {
+		var (
+			row, column   = cell / columns, cell % columns
+			pixels        = dst[row*blockSize*stride+column*blockSize*channels:][:blockSize*channels]
+			finder, black bool
+		)
+
+		// finder patterns: corners of the top and bottom rows only
+		if row < constants.FinderSize || row >= rows-constants.FinderSize {
+			finder, black = layout.finderModule(column, row)
+		}
 
-			// convert to grayscale
-			gray := (r + g + b) / 3
+		if finder {
+			if black {
+				clear(pixels)
+			}
+		} else if next < len(headerCells) && index >= headerCells[next] {
+			// header copies: always one bit per cell so the header stays readable
+			// 1 -> black - 0 -> white, then the calibration patch levels
+			bit := index - headerCells[next]
+
+			if level := cellLevel(headerBits, bit); level != 0xFF {
+				for i := range pixels {
+					pixels[i] = level
+				}
+			}
 
-			// shift current byte and add new bit (0:white --- 1:black)
-			currentByte = currentByte << 1
-			if gray < 32768 { //  pixel is closer to black than white
-				currentByte |= 1
+			if bit == span-1 {
+				next++
+			}
+		} else if symbol < symbols {
+			// payload cells: BitsPerPixel bits per cell (and per R, G, B channel) mapped onto gray
+			// levels, missing channels of the last cell stay white
+			for c := range channels {
+				if symbol+c < symbols {
+					pixels[c] = levels[symbolAt(body, symbol+c, layout.BitsPerPixel)]
+				}
 			}
 
-			bitCount++
+			for i := channels; i < len(pixels); i += channels {
+				copy(pixels[i:i+channels], pixels[:channels])
+			}
 
-			// write every 8 bits (1 Byte)
-			if bitCount == 8 {
-				frameData.WriteByte(currentByte)
+			symbol += channels
+		}
 
-				currentByte = 0
-				bitCount = 0
+		if !finder {
+			index++
+		}
+
+		// cell row done: its first line is copied to the other lines of the row
+		if column == columns-1 || cell == cells-1 {
+			line := dst[row*blockSize*stride:][:columns*blockSize*channels]
+
+			for y := 1; y < blockSize; y++ {
+				copy(dst[(row*blockSize+y)*stride:], line)
 			}
+		}
+	}
+
+	return dst, nil
+}
+
+// RawImage wraps a raw video frame of the given pixel format (gray or rgb24) into an image,
+// gray frames are used in place
+func RawImage(pix []byte, width, height int, pixelFormat string) image.Image {
+	rect := image.Rect(0, 0, width, height)
+
+	if pixelFormat != "rgb24" {
+		return &image.Gray{Pix: pix, Stride: width, Rect: rect}
+	}
+
+	img := image.NewRGBA(rect)
 
-			// enough bytes for a header => check for magic string
-			if frameData.Len() >= len(constants.MagicString) {
-				data = frameData.Bytes()
+	for i, j := 0, 0; i+2 < len(pix) && j < len(img.Pix); i, j = i+3, j+4 {
+		copy(img.Pix[j:j+3], pix[i:i+3])
+		img.Pix[j+3] = 0xFF
+	}
 
-				if bytes.Contains(data, []byte(constants.MagicString)) {
+	return img
+}
 
-					// found magic string
-					magicPos := bytes.Index(data, []byte(constants.MagicString))
+// ProcessFrame extracts data from a frame and returns the payload with its metadata.
+// The frame layout is read from its header copies, legacy YTDSv3 frames are decoded as black & white.
+// Frames are binarised with the Otsu threshold of their histogram, inverted frames are detected
+// from their header. Frames whose header is not found at their size (scaled, cropped or letterboxed
+// by a platform) are resampled from their finder patterns. The payload never references the frame pixels.
+func ProcessFrame(img image.Image) (types.Frame, error) {
+	var (
+		err       error
+		header    Header
+		binary    *symbolTable
+		p         = newPlane(img)
+		threshold = otsuThreshold(p)
+	)
 
-					if magicPos > 0 {
-						data = data[magicPos:]
-						frameData.Reset()
-						frameData.Write(data)
-					}
+	if header, binary, err = findHeader(p, threshold); err == nil {
+		return processFrame(p, header, calibrate(p, header, binary))
+	}
 
-					// complete header => stop collecting
-					if frameData.Len() >= constants.HeaderSize {
+	if resampled, ok := resampleFrame(p, threshold); ok {
+		if header, binary, err = findHeader(resampled, threshold); err == nil {
+			return processFrame(resampled, header, calibrate(resampled, header, binary))
+		}
+	}
 
-						data = frameData.Bytes()
+	// legacy frames, frames with the current magic string are not
+	if !errors.Is(err, errMagicNotFound) {
+		return types.Frame{}, err
+	}
 
-						// checksum verif
-						if !bytes.Equal(checksum.ComputeChecksum(data[:30])[:2], data[30:32]) {
-							continue
-						}
+	if frame, legacyErr := processLegacyFrame(p, threshold); legacyErr == nil {
+		return frame, nil
+	}
 
-						// metadata parsing
-						_ = binary.BigEndian.Uint64(data[6:14])
+	return types.Frame{}, err
+}
+
+// findHeader reads the header of a frame binarised with threshold, then with the inverted
+// polarity when no magic string is found, and returns the binary symbols it was read with
+func findHeader(p plane, threshold uint8) (Header, *symbolTable, error) {
+	var (
+		header Header
+		err    error
+	)
 
-						chunkSize := binary.BigEndian.Uint32(data[18:22])
+	for _, inverted := range []bool{false, true} {
+		binary := binarySymbols(threshold, inverted)
 
-						// remaining neede bytes
-						bytesNeeded := int(chunkSize) + constants.HeaderSize - frameData.Len()
-						if bytesNeeded <= 0 {
-							// enough data
-							break
-						}
-					}
+		if header, err = detectHeader(p, binary); err == nil {
+			return header, binary, nil
+		}
+
+		// frames with the current magic string are not inverted
+		if !errors.Is(err, errMagicNotFound) {
+			break
+		}
+	}
+
+	return Header{}, nil, err
+}
+
+// detectHeader reads the header of a frame from its copies with the binary symbols of the frame:
+// the first valid one is used, or the bitwise majority vote of the copies when none is. The block
+// size is estimated from the first row, every other block size is tried as a fallback: the magic
+// string of the copies is read first, so that block sizes and unreadable frames are ruled out
+// without reading the whole copies.
+func detectHeader(p plane, binary *symbolTable) (Header, error) {
+	var (
+		header    Header
+		err       error
+		headerErr = errMagicNotFound
+		isMagic   = func(data []byte) bool { return string(data) == constants.MagicString }
+	)
+
+	for _, blockSize := range blockSizeCandidates(p, binary) {
+		layout := Layout{Width: p.width, Height: p.height, BitsPerPixel: 1, BlockSize: blockSize}
+
+		if layout.Validate() != nil {
+			continue
+		}
+
+		if !slices.ContainsFunc(headerCopies(p, layout, len(constants.MagicString), binary), isMagic) {
+			continue
+		}
+
+		for _, data := range headerCopies(p, layout, constants.HeaderSize, binary) {
+			if header, err = ParseHeader(data); err != nil {
+				// keep the most relevant error: a header with a valid magic string
+				if !errors.Is(err, errMagicNotFound) {
+					headerErr = err
 				}
+
+				continue
+			}
+
+			if header.Layout.BlockSize != blockSize {
+				continue
+			}
+
+			if header.Layout.Width != layout.Width || header.Layout.Height != layout.Height {
+				headerErr = fmt.Errorf("frame geometry mismatch: encoded %dx%d, got %dx%d",
+					header.Layout.Width, header.Layout.Height, layout.Width, layout.Height)
+
+				continue
 			}
+
+			return header, nil
 		}
 	}
 
-	data = frameData.Bytes()
+	return Header{}, headerErr
+}
+
+// headerCopies returns the first size bytes of every header copy of a frame, followed by their
+// bitwise majority vote when the frame has three copies
+func headerCopies(p plane, layout Layout, size int, binary *symbolTable) [][]byte {
+	var copies [][]byte
+
+	for _, start := range layout.headerCells() {
+		copies = append(copies, readBody(p, layout, start, size, nil, binary))
+	}
+
+	if len(copies) == constants.HeaderCopies {
+		copies = append(copies, majorityVote(copies))
+	}
+
+	return copies
+}
+
+// blockSizeCandidates returns the block sizes to probe, most likely first: the magic string
+// starts with "Y" (01011001) so the first row begins with a white then a black run of one block each,
+// the white run being longer when the white border of a finder pattern comes first
+func blockSizeCandidates(p plane, binary *symbolTable) []int {
+	var (
+		candidates []int
+		seen       = make(map[int]bool)
+		runs       = [2]int{}
+		run        = 0
+	)
+
+	for x := 0; x < p.width && p.height > 0; x++ {
+		// color change: white run => black run => done
+		if black := binary[0][p.gray(image.Rect(x, 0, x+1, 1))] == 1; black != (run == 1) {
+			if run == 1 {
+				break
+			}
+
+			run = 1
+		}
+
+		runs[run]++
+	}
 
-	// find magic string header
-	magic := []byte(constants.MagicString)
-	headerPos := bytes.Index(data, magic)
+	estimate := (runs[0] + runs[1] + 1) / 2
 
-	if headerPos == -1 {
-		return nil, 0, 0, errors.New("magic string not found")
+	for _, blockSize := range []int{runs[1], runs[1] - 1, runs[1] + 1, estimate, estimate - 1, estimate + 1} {
+		if blockSize >= 1 && blockSize <= constants.MaxBlockSize && !seen[blockSize] {
+			candidates = append(candidates, blockSize)
+			seen[blockSize] = true
+		}
 	}
 
-	if headerPos > 0 {
-		data = data[headerPos:]
+	for blockSize := 1; blockSize <= constants.MaxBlockSize; blockSize++ {
+		if !seen[blockSize] {
+			candidates = append(candidates, blockSize)
+		}
 	}
 
-	// header verif size
-	if len(data) < constants.HeaderSize {
-		return nil, 0, 0, fmt.Errorf("incomplete header (%d bytes)", len(data))
+	return candidates
+}
+
+// processFrame extracts and verifies the payload of a frame described by its header,
+// with the symbol tables learnt from the frame
+func processFrame(p plane, header Header, symbols *symbolTable) (types.Frame, error) {
+	var (
+		layout    = header.Layout
+		payload   []byte
+		corrected int
+		codec     *fec.Codec
+		err       error
+	)
+
+	if layout.Parity == 0 {
+		payload = readBody(p, layout, 0, int(header.ChunkSize), layout.headerCells(), symbols)
+	} else {
+		// correct the whole body before the payload checksum
+		if codec, err = fec.NewCodec(layout.Parity); err != nil {
+			return types.Frame{}, fmt.Errorf("fec error: %w", err)
+		}
+
+		payload, corrected = codec.DecodeInterleaved(readBody(p, layout, 0, layout.BodySize(), layout.headerCells(), symbols))
+		payload = payload[:header.ChunkSize]
 	}
 
-	// header checksum
-	if !bytes.Equal(checksum.ComputeChecksum(data[:30])[:2], data[30:32]) {
-		return nil, 0, 0, errors.New("header checksum mismatch")
+	if header.ChecksumAlgorithm.Sum(payload) != header.Checksum {
+		return types.Frame{}, errors.New("payload checksum mismatch")
 	}
 
-	// parse metadata
-	totalSize := binary.BigEndian.Uint64(data[6:14])
-	sequence := int(binary.BigEndian.Uint32(data[14:18]))
-	chunkSize := binary.BigEndian.Uint32(data[18:22])
-	storedChecksum := binary.BigEndian.Uint64(data[22:30])
+	return types.Frame{
+		Kind:         header.Kind,
+		Sequence:     int(header.Sequence),
+		TotalSize:    header.TotalSize,
+		Compression:  header.Compression,
+		Encrypted:    header.Flags&FlagEncrypted != 0,
+		Payload:      payload,
+		TotalFrames:  int(header.TotalFrames),
+		Corrected:    corrected,
+		Capacity:     layout.PayloadSize(),
+		GroupSize:    layout.GroupSize,
+		ParityFrames: layout.ParityFrames,
+	}, nil
+}
+
+// readBody extracts `size` bytes from the cells starting at `start`: BitsPerPixel bits per cell
+// (and per R, G, B channel) mapped from their levels by the symbol tables. The cells of the header
+// copies starting at headerCells are skipped, missing cells read as zeros.
+func readBody(p plane, layout Layout, start, size int, headerCells []int, symbols *symbolTable) []byte {
+	var (
+		body        = make([]byte, 0, size+2)
+		cells       = layout.Cells()
+		channels    = layout.ColorMode.Channels()
+		rect        = layout.sampleRect(start)
+		column, row = layout.cellPosition(start)
+		end         = layout.rowEnd(row)
+		next        = 0
+		levels      [3]uint8
+		currentByte byte
+		bitCount    = 0
+	)
 
-	// validate chunk size
-	if int(chunkSize) > len(data)-constants.HeaderSize {
-		return nil, 0, 0, fmt.Errorf("invalid chunk size %d", chunkSize)
+	for next < len(headerCells) && headerCells[next] < start {
+		next++
 	}
 
-	// extract payload
-	payload = data[constants.HeaderSize : constants.HeaderSize+int(chunkSize)]
+	for cell := start; len(body) < size && cell < cells; cell++ {
+		// header copies are skipped
+		for next < len(headerCells) && cell == headerCells[next] {
+			cell += layout.headerSpan()
+			next++
+
+			rect = layout.sampleRect(cell)
+			column, row = layout.cellPosition(cell)
+			end = layout.rowEnd(row)
+		}
+
+		if cell >= cells {
+			break
+		}
+
+		// centre of the cell, averaged over the channels of gray layouts
+		if channels == 1 {
+			levels[0] = p.gray(rect)
+		} else {
+			levels = p.levels(rect)
+		}
+
+		// next cell: same row or first cell of the next row (after the finder patterns)
+		if column++; column < end {
+			rect = rect.Add(image.Pt(layout.BlockSize, 0))
+		} else {
+			rect = layout.sampleRect(cell + 1)
+			column, row = layout.cellPosition(cell + 1)
+			end = layout.rowEnd(row)
+		}
+
+		for c, level := range levels[:channels] {
+			currentByte = currentByte<<layout.BitsPerPixel | symbols[c][level]
+			bitCount += layout.BitsPerPixel
+
+			if bitCount == 8 {
+				body = append(body, currentByte)
+
+				currentByte = 0
+				bitCount = 0
+			}
+		}
+	}
 
-	if checksum.CRC64(payload) != storedChecksum {
-		return nil, 0, 0, errors.New("payload checksum mismatch")
+	if len(body) < size {
+		body = append(body, make([]byte, size-len(body))...)
 	}
 
-	return payload, totalSize, sequence, nil
+	return body[:size]
 }
@@ -0,0 +1,292 @@
+package frame
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/sabouaram/data2vid/internal/checksum"
+	"github.com/sabouaram/data2vid/internal/constants"
+	"github.com/sabouaram/data2vid/internal/types"
+)
+
+// benchmarkLayouts are 1280x720 layouts from the densest to the most robust
+var benchmarkLayouts = []struct {
+	name   string
+	layout Layout
+}{
+	{"gray-1bit-block1", Layout{Width: 1280, Height: 720, BitsPerPixel: 1, BlockSize: 1, ColorMode: ColorGray}},
+	{"gray-2bits-block2", Layout{Width: 1280, Height: 720, BitsPerPixel: 2, BlockSize: 2, ColorMode: ColorGray}},
+	{"rgb-4bits-block4-fec32", Layout{Width: 1280, Height: 720, BitsPerPixel: 4, BlockSize: 4, ColorMode: ColorRGB, Parity: 32}},
+}
+
+// testPayload returns size bytes of deterministic random data
+func testPayload(size int) []byte {
+	data := make([]byte, size)
+
+	rand.NewChaCha8([32]byte{}).Read(data)
+
+	return data
+}
+
+// testHeader returns the header template of a data stream of size bytes
+func testHeader(layout Layout, size int) Header {
+	return Header{Kind: types.KindData, Layout: layout, TotalSize: uint64(size), ChecksumAlgorithm: checksum.CRC64}
+}
+
+// createFrames renders the frames of data with the layout
+func createFrames(t *testing.T, data []byte, layout Layout) ([]image.Image, error) {
+	t.Helper()
+
+	var (
+		rendered bytes.Buffer
+		sink     = &Sink{Output: &rendered}
+		size     = layout.Width * layout.Height * layout.ColorMode.Channels()
+		frames   []image.Image
+	)
+
+	if err := CreateFrames(sink, bytes.NewReader(data), testHeader(layout, len(data))); err != nil {
+		return nil, err
+	}
+
+	for i := range sink.Frames {
+		frames = append(frames, RawImage(rendered.Bytes()[i*size:(i+1)*size], layout.Width, layout.Height, layout.ColorMode.RawPixelFormat()))
+	}
+
+	return frames, nil
+}
+
+// decodeFrames decodes the frames of data and checks that they carry it in sequence order
+func decodeFrames(t *testing.T, frames []image.Image, data []byte) {
+	t.Helper()
+
+	var joined []byte
+
+	for i, img := range frames {
+		frame, err := ProcessFrame(img)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+
+		if frame.Sequence != i || frame.TotalSize != uint64(len(data)) {
+			t.Fatalf("frame %d: sequence %d, total size %d", i, frame.Sequence, frame.TotalSize)
+		}
+
+		joined = append(joined, frame.Payload...)
+	}
+
+	if !bytes.Equal(joined, data) {
+		t.Fatal("data not decoded")
+	}
+}
+
+func TestFramesRoundTrip(t *testing.T) {
+	tests := []struct {
+		bits, blockSize int
+		mode            ColorMode
+	}{
+		{1, 1, ColorGray},
+		{2, 1, ColorGray},
+		{4, 1, ColorGray},
+		{8, 1, ColorGray},
+		{1, 2, ColorGray},
+		{2, 3, ColorGray}, // pixels left over on the right and bottom edges
+		{4, 4, ColorGray},
+		{1, 1, ColorRGB},
+		{2, 2, ColorRGB},
+		{8, 1, ColorRGB},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d bits block %d", tt.mode, tt.bits, tt.blockSize), func(t *testing.T) {
+			var (
+				layout = Layout{Width: 160, Height: 120, BitsPerPixel: tt.bits, BlockSize: tt.blockSize, ColorMode: tt.mode}
+				data   = testPayload(2*layout.PayloadSize() + layout.PayloadSize()/2)
+			)
+
+			frames, err := createFrames(t, data, layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(frames) != 3 {
+				t.Fatalf("%d frames, expected 3", len(frames))
+			}
+
+			decodeFrames(t, frames, data)
+		})
+	}
+}
+
+// only the centre of the blocks is sampled: smeared block edges do not change the symbols
+func TestBlockEdgesIgnored(t *testing.T) {
+	var (
+		layout = Layout{Width: 160, Height: 120, BitsPerPixel: 2, BlockSize: 4}
+		data   = testPayload(layout.PayloadSize())
+		r      = rand.New(rand.NewPCG(1, 2))
+	)
+
+	frames, err := createFrames(t, data, layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gray := frames[0].(*image.Gray)
+
+	for y := range layout.Height {
+		for x := range layout.Width {
+			if x%layout.BlockSize == 0 || y%layout.BlockSize == layout.BlockSize-1 {
+				gray.Pix[y*gray.Stride+x] = uint8(r.IntN(256))
+			}
+		}
+	}
+
+	decodeFrames(t, frames, data)
+}
+
+// a band of damaged cell rows is corrected with parity, and fails the checksum without
+func TestFramesCorrected(t *testing.T) {
+	for _, parity := range []int{0, 32} {
+		var (
+			layout = Layout{Width: 160, Height: 120, BitsPerPixel: 1, BlockSize: 1, Parity: parity}
+			data   = testPayload(layout.PayloadSize())
+		)
+
+		frames, err := createFrames(t, data, layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gray := frames[0].(*image.Gray)
+
+		// rows of payload cells, between the top and middle header copies
+		for y := 40; y < 42; y++ {
+			for x := range layout.Width {
+				gray.Pix[y*gray.Stride+x] ^= 0xff
+			}
+		}
+
+		frame, err := ProcessFrame(gray)
+
+		switch {
+		case parity == 0 && err == nil:
+			t.Fatal("damaged frame decoded without parity")
+		case parity == 0:
+		case err != nil:
+			t.Fatalf("parity %d: %v", parity, err)
+		case !bytes.Equal(frame.Payload, data) || frame.Corrected == 0:
+			t.Fatalf("parity %d: payload not corrected", parity)
+		}
+	}
+}
+
+// a frame is decoded from its other header copies when one of them is overwritten
+func TestHeaderCopyOverwritten(t *testing.T) {
+	var (
+		layout = Layout{Width: 160, Height: 120, BitsPerPixel: 1, BlockSize: 1}
+		data   = testPayload(layout.PayloadSize())
+	)
+
+	for i, first := range layout.headerCells() {
+		frames, err := createFrames(t, data, layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// one pixel per cell
+		gray := frames[0].(*image.Gray)
+
+		for cell := first; cell < first+constants.HeaderSize*8; cell++ {
+			x, y := layout.cellPosition(cell)
+			gray.Pix[y*gray.Stride+x] ^= 0xff
+		}
+
+		frame, err := ProcessFrame(gray)
+		if err != nil {
+			t.Fatalf("copy %d: %v", i, err)
+		}
+
+		if !bytes.Equal(frame.Payload, data) {
+			t.Fatalf("copy %d: payload mismatch", i)
+		}
+	}
+}
+
+// BenchmarkCreateFrames measures the frames rendered per second on one CPU, a full payload per frame
+func BenchmarkCreateFrames(b *testing.B) {
+	for _, bench := range benchmarkLayouts {
+		b.Run(bench.name, func(b *testing.B) {
+			var (
+				data   = testPayload(bench.layout.PayloadSize())
+				header = testHeader(bench.layout, len(data))
+				sink   = &Sink{Output: io.Discard}
+			)
+
+			for b.Loop() {
+				if err := CreateFrames(sink, bytes.NewReader(data), header); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "frames/s")
+		})
+	}
+}
+
+// BenchmarkProcessFrame measures the frames decoded per second on one CPU
+func BenchmarkProcessFrame(b *testing.B) {
+	for _, bench := range benchmarkLayouts {
+		b.Run(bench.name, func(b *testing.B) {
+			var (
+				layout = bench.layout
+				data   = testPayload(layout.PayloadSize())
+			)
+
+			pixels, err := renderPixels(nil, testHeader(layout, len(data)), data)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			img := RawImage(pixels, layout.Width, layout.Height, layout.ColorMode.RawPixelFormat())
+
+			for b.Loop() {
+				frame, err := ProcessFrame(img)
+				if err != nil || !bytes.Equal(frame.Payload, data) {
+					b.Fatalf("frame not decoded: %v", err)
+				}
+			}
+
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "frames/s")
+		})
+	}
+}
+
+// BenchmarkProcessFrameUnreadable measures how fast frames without any header are rejected,
+// such as the frames of a video not produced by the encoder
+func BenchmarkProcessFrameUnreadable(b *testing.B) {
+	var (
+		blank = make([]byte, 1280*720)
+		noise = testPayload(1280 * 720)
+	)
+
+	for _, bench := range []struct {
+		name   string
+		pixels []byte
+	}{
+		{"blank", blank},
+		{"noise", noise},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			img := RawImage(bench.pixels, 1280, 720, "gray")
+
+			for b.Loop() {
+				if _, err := ProcessFrame(img); err == nil {
+					b.Fatal("unreadable frame decoded")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package frame
+
+import (
+	"bytes"
+	"math"
+	"slices"
+
+	"github.com/sabouaram/data2vid/internal/constants"
+)
+
+// Frames scaled, cropped or letterboxed by a platform no longer have their cells where the
+// layout puts them. Frames carry a finder pattern in every corner of their cell grid:
+//
+//	+---+---+---+---+---+---+---+---+---+
+//	|   |   |   |   |   |   |   |   |   |   white border (frame edges, separator from the data)
+//	+---+---+---+---+---+---+---+---+---+
+//	|   | B | B | B | B | B | B | B |   |
+//	+---+---+---+---+---+---+---+---+---+
+//	|   | B |   |   |   |   |   | B |   |
+//	+---+---+---+---+---+---+---+---+---+
+//	|   | B |   | B | B | B |   | B |   |   1:1:3:1:1 black and white runs
+//	+---+---+---+---+---+---+---+---+---+   across the centre in every direction
+//	|   | B |   | B | B | B |   | B |   |
+//	+---+---+---+---+---+---+---+---+---+
+//	          ...
+//
+// The decoder locates the top left, top right and bottom left patterns, derives the affine
+// transform mapping the cell grid onto the frame, finds the number of columns of the grid from
+// the top header copy, then resamples the frame to its encoded size before decoding it.
+//
+// Interpolation blurs the cells of small blocks: frames with a BlockSize of 2 are recovered when
+// upscaled, stretched or letterboxed at their size, but not when downscaled (0.75 scale, 0.8 scale
+// letterbox) or rotated, which needs a BlockSize of 4 or more.
+
+// minFinderModule is the smallest finder pattern cell looked for, in pixels: smaller cells are
+// too blurred by scaling to be resampled, and noise is full of 1 pixel 1:1:3:1:1 runs
+const minFinderModule = 2
+
+// finder is a finder pattern located in a frame: its centre and the size of its cells in pixels
+type finder struct {
+	x, y   float64
+	module float64
+	hits   int
+}
+
+// affine maps cell grid coordinates (in cells, from the top left corner) to frame pixels
+type affine struct {
+	x, y   float64
+	column [2]float64
+	row    [2]float64
+}
+
+// apply returns the frame pixel coordinates of a point of the cell grid
+func (a affine) apply(column, row float64) (float64, float64) {
+	return a.x + column*a.column[0] + row*a.row[0], a.y + column*a.column[1] + row*a.row[1]
+}
+
+// gridTransform returns the transform of a grid of columns x rows cells whose top left, top right
+// and bottom left finder patterns are located at tl, tr and bl
+func gridTransform(tl, tr, bl finder, columns, rows int) affine {
+	var (
+		centre = float64(constants.FinderSize) / 2
+		a      = affine{
+			column: [2]float64{(tr.x - tl.x) / (float64(columns) - 2*centre), (tr.y - tl.y) / (float64(columns) - 2*centre)},
+			row:    [2]float64{(bl.x - tl.x) / (float64(rows) - 2*centre), (bl.y - tl.y) / (float64(rows) - 2*centre)},
+		}
+	)
+
+	a.x = tl.x - centre*(a.column[0]+a.row[0])
+	a.y = tl.y - centre*(a.column[1]+a.row[1])
+
+	return a
+}
+
+// resampleFrame returns the frame resampled to its encoded size from its finder patterns,
+// when they are found and the top header copy is read from the cell grid they locate
+func resampleFrame(p plane, threshold uint8) (plane, bool) {
+	for polarity, candidates := range locateFinders(p, binarySymbols(threshold, false)) {
+		var (
+			binary     = binarySymbols(threshold, polarity == 1)
+			tl, tr, bl finder
+			ok         bool
+		)
+
+		if tl, tr, bl, ok = cornerFinders(candidates); !ok {
+			continue
+		}
+
+		var (
+			module  = (tl.module + tr.module + bl.module) / 3
+			columns = int(math.Round(math.Hypot(tr.x-tl.x, tr.y-tl.y)/module)) + constants.FinderSize
+			rows    = int(math.Round(math.Hypot(bl.x-tl.x, bl.y-tl.y)/module)) + constants.FinderSize
+		)
+
+		// the module size is only an estimate: the columns are confirmed by the header, which
+		// gives the exact grid
+		candidates := []int{columns}
+
+		for offset := 1; offset <= max(2, columns/8); offset++ {
+			candidates = append(candidates, columns+offset, columns-offset)
+		}
+
+		for _, candidate := range candidates {
+			header, err := gridHeader(p, binary, tl, tr, bl, candidate, rows)
+			if err != nil {
+				continue
+			}
+
+			var (
+				layout    = header.Layout
+				transform = gridTransform(tl, tr, bl, layout.Columns(), layout.Rows())
+			)
+
+			return resample(p, layout.Width, layout.Height, float64(layout.BlockSize), transform), true
+		}
+	}
+
+	return plane{}, false
+}
+
+// gridHeader reads the top header copy of a grid of columns cells (rows being an estimate),
+// sampling the centre of every cell
+func gridHeader(p plane, binary *symbolTable, tl, tr, bl finder, columns, rows int) (Header, error) {
+	var (
+		layout = Layout{
+			Width:        columns,
+			Height:       rows,
+			BitsPerPixel: 1,
+			BlockSize:    1,
+		}
+		transform = gridTransform(tl, tr, bl, columns, rows)
+		data      []byte
+		header    Header
+		err       error
+	)
+
+	if layout.Validate() != nil {
+		return header, errMagicNotFound
+	}
+
+	// one pixel per cell, only the rows of the magic string then of the top copy
+	for _, size := range []int{len(constants.MagicString), constants.HeaderSize} {
+		_, last := layout.cellPosition(size*8 - 1)
+		data = readBody(resample(p, columns, last+1, 1, transform), layout, 0, size, nil, binary)
+
+		if !bytes.HasPrefix(data, []byte(constants.MagicString)) {
+			return header, errMagicNotFound
+		}
+	}
+
+	if header, err = ParseHeader(data); err != nil {
+		return header, err
+	}
+
+	if header.Layout.Columns() != columns {
+		return header, errMagicNotFound
+	}
+
+	return header, nil
+}
+
+// resample returns a width x height frame whose pixel centres are mapped to the cell grid
+// (scale pixels per cell) then to the frame p by transform, nearest pixel. Pixels outside
+// of p are white.
+func resample(p plane, width, height int, scale float64, transform affine) plane {
+	var (
+		stride    = width * p.step
+		resampled = plane{pix: make([]byte, stride*height), stride: stride, step: p.step, width: width, height: height}
+	)
+
+	for y := range height {
+		for x := range width {
+			var (
+				sx, sy = transform.apply((float64(x)+0.5)/scale, (float64(y)+0.5)/scale)
+				px, py = int(math.Floor(sx)), int(math.Floor(sy))
+				dst    = resampled.pix[y*stride+x*p.step:][:p.step]
+			)
+
+			if px < 0 || py < 0 || px >= p.width || py >= p.height {
+				for i := range dst {
+					dst[i] = 0xFF
+				}
+
+				continue
+			}
+
+			copy(dst, p.pix[py*p.stride+px*p.step:])
+		}
+	}
+
+	return resampled
+}
+
+// locateFinders returns the finder patterns of a frame read with the binary symbols of its
+// threshold, then the ones of the inverted frame: every other row is scanned for 1:1:3:1:1 dark
+// and light runs of at least minFinderModule pixels per cell, confirmed across the column of their
+// centre, and the patterns found on several rows are kept
+func locateFinders(p plane, binary *symbolTable) [2][]finder {
+	var finders [2][]finder
+
+	for y := 0; y < p.height; y += 2 {
+		var (
+			runs   [5]int
+			count  = 0
+			symbol = binary[0][p.pixel(0, y)]
+		)
+
+		for x := 0; x <= p.width; x++ {
+			// a run ends at a color change or at the end of the row
+			if x < p.width && binary[0][p.pixel(x, y)] == symbol {
+				runs[4]++
+
+				continue
+			}
+
+			// dark, light, dark, light, dark runs: black ones, or white ones in inverted frames
+			if count >= 4 && finderRatio(runs) {
+				centre := float64(x) - float64(runs[4]+runs[3]) - float64(runs[2])/2
+
+				if f, found := crossCheck(p, binary, symbol, int(centre), y, float64(runs[0]+runs[1]+runs[2]+runs[3]+runs[4])); found {
+					f.x = centre
+					finders[1-symbol] = addFinder(finders[1-symbol], f)
+				}
+			}
+
+			if x < p.width {
+				copy(runs[:], runs[1:])
+				runs[4] = 1
+				symbol ^= 1
+				count++
+			}
+		}
+	}
+
+	for polarity := range finders {
+		finders[polarity] = slices.DeleteFunc(finders[polarity], func(f finder) bool { return f.hits < 2 })
+	}
+
+	return finders
+}
+
+// cornerFinders returns the top left, top right and bottom left finder patterns of a frame,
+// the ones nearest to its corners
+func cornerFinders(finders []finder) (tl, tr, bl finder, ok bool) {
+	if len(finders) < 3 {
+		return tl, tr, bl, false
+	}
+
+	tl, tr, bl = finders[0], finders[0], finders[0]
+
+	for _, f := range finders {
+		if f.x+f.y < tl.x+tl.y {
+			tl = f
+		}
+
+		if f.x-f.y > tr.x-tr.y {
+			tr = f
+		}
+
+		if f.y-f.x > bl.y-bl.x {
+			bl = f
+		}
+	}
+
+	// the three patterns are distinct and far apart
+	if math.Hypot(tr.x-tl.x, tr.y-tl.y) < 2*constants.FinderSize*tl.module ||
+		math.Hypot(bl.x-tl.x, bl.y-tl.y) < 2*constants.FinderSize*tl.module {
+		return tl, tr, bl, false
+	}
+
+	return tl, tr, bl, true
+}
+
+// finderRatio reports whether dark, light, dark, light, dark runs are 1:1:3:1:1
+func finderRatio(runs [5]int) bool {
+	module := float64(runs[0]+runs[1]+runs[2]+runs[3]+runs[4]) / 7
+
+	if module < minFinderModule {
+		return false
+	}
+
+	for i, run := range runs {
+		expected := module
+
+		if i == 2 {
+			expected = 3 * module
+		}
+
+		if math.Abs(float64(run)-expected) > module/2+0.5 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// crossCheck confirms a finder pattern of dark symbol in the column of its horizontal centre: the
+// runs above and below the centre are 1:1:3:1:1 with a similar total length. It returns the
+// vertical centre.
+func crossCheck(p plane, binary *symbolTable, dark byte, x, y int, total float64) (finder, bool) {
+	var (
+		runs [5]int
+		up   = y
+		down = y + 1
+	)
+
+	isBlack := func(y int) bool {
+		return binary[0][p.pixel(x, y)] == dark
+	}
+
+	// centre run, then the white and black runs on each side
+	for ; up >= 0 && isBlack(up); up-- {
+		runs[2]++
+	}
+
+	for ; down < p.height && isBlack(down); down++ {
+		runs[2]++
+	}
+
+	centre := float64(up+1) + float64(runs[2])/2
+
+	for i, black := range []bool{false, true} {
+		for ; up >= 0 && isBlack(up) == black && runs[1-i] <= int(total); up-- {
+			runs[1-i]++
+		}
+
+		for ; down < p.height && isBlack(down) == black && runs[3+i] <= int(total); down++ {
+			runs[3+i]++
+		}
+	}
+
+	vertical := float64(runs[0] + runs[1] + runs[2] + runs[3] + runs[4])
+
+	if !finderRatio(runs) || math.Abs(vertical-total) > total/2 {
+		return finder{}, false
+	}
+
+	return finder{y: centre, module: (vertical + total) / 14, hits: 1}, true
+}
+
+// addFinder merges a finder pattern with the one found at the same place on previous rows
+func addFinder(finders []finder, f finder) []finder {
+	for i, other := range finders {
+		if math.Abs(other.x-f.x) < 2*other.module && math.Abs(other.y-f.y) < 2*other.module {
+			hits := float64(other.hits)
+
+			finders[i] = finder{
+				x:      (other.x*hits + f.x) / (hits + 1),
+				y:      (other.y*hits + f.y) / (hits + 1),
+				module: (other.module*hits + f.module) / (hits + 1),
+				hits:   other.hits + 1,
+			}
+
+			return finders
+		}
+	}
+
+	return append(finders, f)
+}
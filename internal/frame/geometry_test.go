@@ -0,0 +1,97 @@
+package frame
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+	"testing"
+)
+
+// warp returns a width x height frame whose pixel centres are mapped back into img by source,
+// bilinear interpolation, pixels outside of img painted with the background level
+func warp(img image.Image, width, height int, background uint8, source func(x, y float64) (float64, float64)) image.Image {
+	var (
+		bounds = img.Bounds()
+		gray   = img.(*image.Gray)
+		out    = image.NewGray(image.Rect(0, 0, width, height))
+	)
+
+	at := func(x, y int) float64 {
+		if x < 0 || y < 0 || x >= bounds.Dx() || y >= bounds.Dy() {
+			return float64(background)
+		}
+
+		return float64(gray.Pix[y*gray.Stride+x])
+	}
+
+	for y := range height {
+		for x := range width {
+			var (
+				sx, sy = source(float64(x)+0.5, float64(y)+0.5)
+				x0, y0 = int(math.Floor(sx - 0.5)), int(math.Floor(sy - 0.5))
+				fx, fy = sx - 0.5 - float64(x0), sy - 0.5 - float64(y0)
+				level  = at(x0, y0)*(1-fx)*(1-fy) + at(x0+1, y0)*fx*(1-fy) + at(x0, y0+1)*(1-fx)*fy + at(x0+1, y0+1)*fx*fy
+			)
+
+			out.Pix[y*width+x] = uint8(max(0, min(255, math.Round(level))))
+		}
+	}
+
+	return out
+}
+
+// every geometry is decoded from the smallest block size documented with the finder patterns
+func TestProcessFrameGeometry(t *testing.T) {
+	const width, height = 640, 360
+
+	tests := []struct {
+		name          string
+		width, height int
+		background    uint8
+		source        func(x, y float64) (float64, float64)
+
+		// smallest block size decoded
+		blockSize int
+	}{
+		{"unchanged", width, height, 0, func(x, y float64) (float64, float64) { return x, y }, 1},
+		{"upscaled 1.5", width * 3 / 2, height * 3 / 2, 0, func(x, y float64) (float64, float64) { return x / 1.5, y / 1.5 }, 2},
+		{"letterboxed", 800, 600, 0, func(x, y float64) (float64, float64) { return x - 80, y - 120 }, 2},
+		{"pillarboxed tv black", 854, 360, 16, func(x, y float64) (float64, float64) { return x - 107, y }, 2},
+		{"stretched", 720, 360, 0, func(x, y float64) (float64, float64) { return x * 640 / 720, y }, 2},
+		{"downscaled 0.75", width * 3 / 4, height * 3 / 4, 0, func(x, y float64) (float64, float64) { return x / 0.75, y / 0.75 }, 4},
+		{"downscaled 0.5", width / 2, height / 2, 0, func(x, y float64) (float64, float64) { return x * 2, y * 2 }, 4},
+		{"letterboxed 0.8", 640, 480, 0, func(x, y float64) (float64, float64) { return (x - 64) / 0.8, (y - 96) / 0.8 }, 4},
+		{"rotated 1 degree", width + 40, height + 40, 0, func(x, y float64) (float64, float64) {
+			angle := math.Pi / 180
+			x, y = x-20, y-20
+
+			return x*math.Cos(angle) + y*math.Sin(angle), -x*math.Sin(angle) + y*math.Cos(angle)
+		}, 4},
+	}
+
+	for _, tt := range tests {
+		for _, blockSize := range []int{2, 4} {
+			if blockSize < tt.blockSize {
+				continue
+			}
+
+			t.Run(fmt.Sprintf("%s/block %d", tt.name, blockSize), func(t *testing.T) {
+				var (
+					layout = Layout{Width: width, Height: height, BitsPerPixel: 1, BlockSize: blockSize, ColorMode: ColorGray}
+					data   = testPayload(layout.PayloadSize())
+					img    = renderImage(t, layout, data)
+				)
+
+				frame, err := ProcessFrame(warp(img, tt.width, tt.height, tt.background, tt.source))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !bytes.Equal(frame.Payload, data) {
+					t.Fatal("payload not decoded")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/sabouaram/data2vid/internal/checksum"
+	"github.com/sabouaram/data2vid/internal/compress"
+	"github.com/sabouaram/data2vid/internal/constants"
+	"github.com/sabouaram/data2vid/internal/types"
+)
+
+var errMagicNotFound = errors.New("magic string not found")
+
+const (
+	// FlagEncrypted marks frames whose payload belongs to an encrypted stream
+	FlagEncrypted uint8 = 1 << iota
+)
+
+// Header is the self-describing v4 frame header: it records everything the decoder needs,
+// so frames can be decoded without knowing the settings used to encode them. Copies of the
+// header are written at the top, middle and bottom of the frame, each followed by a calibration
+// patch.
+//
+// +-------------+---------+------+-------+------+-------+-------+--------+--------+--------+-------------+
+// | Magic String| Version | Kind | Flags | Bits | Block | Color |   FEC  | Group  | Parity | Compression |
+// | (6 bytes)   |         |      |       | /px  | Size  | Mode  | Parity |  Size  | Frames |             |
+// +-------------+---------+------+-------+------+-------+-------+--------+--------+--------+-------------+
+// | 0         5 |    6    |   7  |   8   |   9  |   10  |   11  |   12   |   13   |   14   |      15     |
+// +-------------+---------+------+-------+------+-------+-------+--------+--------+--------+-------------+
+//
+// +-------+--------+------------+-------------+------------+--------------+----------+-----------+
+// | Width | Height | Total Size | Sequence #  | Chunk Size | Total Frames |   Data   | Checksum  |
+// |       |        |            |             |            |              | Checksum | Algorithm |
+// +-------+--------+------------+-------------+------------+--------------+----------+-----------+
+// | 16 17 | 18  19 | 20      27 | 28       31 | 32      35 | 36        39 | 40    47 |    48     |
+// +-------+--------+------------+-------------+------------+--------------+----------+-----------+
+//
+// +-------------+-----------------+
+// |  Reserved   | Header Checksum |
+// | 49       59 | 60           63 |
+// +-------------+-----------------+
+//
+// The checksum algorithm selects the data checksum (CRC-32C or CRC-64), the header is protected
+// by its CRC-32C.
+type Header struct {
+	Kind        types.FrameKind
+	Flags       uint8
+	Layout      Layout
+	Compression compress.Algorithm
+	TotalSize   uint64
+	Sequence    uint32
+	ChunkSize   uint32
+	TotalFrames uint32
+	Checksum    uint64
+
+	// algorithm of the data checksum
+	ChecksumAlgorithm checksum.Algorithm
+}
+
+// Marshal encodes the header into its HeaderSize bytes representation
+func (h Header) Marshal() []byte {
+	header := make([]byte, constants.HeaderSize)
+
+	copy(header[:6], []byte(constants.MagicString))
+	header[6] = constants.FormatVersion
+	header[7] = byte(h.Kind)
+	header[8] = h.Flags
+	header[9] = byte(h.Layout.BitsPerPixel)
+	header[10] = byte(h.Layout.BlockSize)
+	header[11] = byte(h.Layout.ColorMode)
+	header[12] = byte(h.Layout.Parity)
+	header[13] = byte(h.Layout.GroupSize)
+	header[14] = byte(h.Layout.ParityFrames)
+	header[15] = byte(h.Compression)
+	binary.BigEndian.PutUint16(header[16:18], uint16(h.Layout.Width))
+	binary.BigEndian.PutUint16(header[18:20], uint16(h.Layout.Height))
+	binary.BigEndian.PutUint64(header[20:28], h.TotalSize)
+	binary.BigEndian.PutUint32(header[28:32], h.Sequence)
+	binary.BigEndian.PutUint32(header[32:36], h.ChunkSize)
+	binary.BigEndian.PutUint32(header[36:40], h.TotalFrames)
+	binary.BigEndian.PutUint64(header[40:48], h.Checksum)
+	header[48] = byte(h.ChecksumAlgorithm)
+	copy(header[60:64], checksum.HeaderSum(header[:60]))
+
+	return header
+}
+
+// ParseHeader decodes and verifies a v4 header
+func ParseHeader(data []byte) (Header, error) {
+	var h Header
+
+	if len(data) < constants.HeaderSize {
+		return h, fmt.Errorf("incomplete header (%d bytes)", len(data))
+	}
+
+	if !bytes.Equal(data[:6], []byte(constants.MagicString)) {
+		return h, errMagicNotFound
+	}
+
+	if !bytes.Equal(checksum.HeaderSum(data[:60]), data[60:64]) {
+		return h, errors.New("header checksum mismatch")
+	}
+
+	if data[6] != constants.FormatVersion {
+		return h, fmt.Errorf("unsupported format version %d", data[6])
+	}
+
+	// the legacy checksum is only used by YTDSv3 frames
+	algorithm := checksum.Algorithm(data[48])
+
+	if algorithm == checksum.LegacyCRC64 || !algorithm.Valid() {
+		return h, fmt.Errorf("unsupported checksum algorithm %d", data[48])
+	}
+
+	h = Header{
+		Kind:  types.FrameKind(data[7]),
+		Flags: data[8],
+		Layout: Layout{
+			Width:        int(binary.BigEndian.Uint16(data[16:18])),
+			Height:       int(binary.BigEndian.Uint16(data[18:20])),
+			BitsPerPixel: int(data[9]),
+			BlockSize:    int(data[10]),
+			ColorMode:    ColorMode(data[11]),
+			Parity:       int(data[12]),
+			GroupSize:    int(data[13]),
+			ParityFrames: int(data[14]),
+		},
+		Compression: compress.Algorithm(data[15]),
+		TotalSize:   binary.BigEndian.Uint64(data[20:28]),
+		Sequence:    binary.BigEndian.Uint32(data[28:32]),
+		ChunkSize:   binary.BigEndian.Uint32(data[32:36]),
+		TotalFrames: binary.BigEndian.Uint32(data[36:40]),
+		Checksum:    binary.BigEndian.Uint64(data[40:48]),
+
+		ChecksumAlgorithm: algorithm,
+	}
+
+	if err := h.Layout.Validate(); err != nil {
+		return h, fmt.Errorf("invalid header layout: %w", err)
+	}
+
+	if !h.Compression.Valid() {
+		return h, fmt.Errorf("unsupported compression %d", data[15])
+	}
+
+	if int(h.ChunkSize) > h.Layout.PayloadSize() {
+		return h, fmt.Errorf("invalid chunk size %d", h.ChunkSize)
+	}
+
+	return h, nil
+}
+
+// majorityVote returns the bitwise majority of header copies
+func majorityVote(copies [][]byte) []byte {
+	vote := make([]byte, len(copies[0]))
+
+	for i := range vote {
+		for bit := 7; bit >= 0; bit-- {
+			count := 0
+
+			for _, c := range copies {
+				count += int(c[i]>>bit) & 1
+			}
+
+			if count*2 > len(copies) {
+				vote[i] |= 1 << bit
+			}
+		}
+	}
+
+	return vote
+}
@@ -0,0 +1,138 @@
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/sabouaram/data2vid/internal/checksum"
+	"github.com/sabouaram/data2vid/internal/compress"
+	"github.com/sabouaram/data2vid/internal/types"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		header Header
+	}{
+		{"data", Header{
+			Kind:        types.KindData,
+			Layout:      Layout{Width: 1280, Height: 720, BitsPerPixel: 1, BlockSize: 1},
+			TotalSize:   6 << 20,
+			Sequence:    41,
+			ChunkSize:   114943,
+			TotalFrames: 55,
+			Checksum:    0x0123456789abcdef,
+
+			ChecksumAlgorithm: checksum.CRC64,
+		}},
+		{"compressed parity", Header{
+			Kind: types.KindParity,
+			Layout: Layout{
+				Width: 1920, Height: 1080, BitsPerPixel: 4, BlockSize: 4, ColorMode: ColorRGB,
+				Parity: 32, GroupSize: 10, ParityFrames: 2,
+			},
+			Compression: compress.Zstd,
+			TotalSize:   1 << 40,
+			Sequence:    7,
+			ChunkSize:   1000,
+			TotalFrames: 1 << 30,
+			Checksum:    0xdeadbeef,
+
+			ChecksumAlgorithm: checksum.CRC32C,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := ParseHeader(tt.header.Marshal())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if parsed != tt.header {
+				t.Fatalf("got %+v, expected %+v", parsed, tt.header)
+			}
+		})
+	}
+}
+
+func TestParseHeaderErrors(t *testing.T) {
+	valid := Header{
+		Kind:        types.KindData,
+		Layout:      Layout{Width: 1280, Height: 720, BitsPerPixel: 1, BlockSize: 1},
+		TotalSize:   1000,
+		ChunkSize:   1000,
+		TotalFrames: 1,
+
+		ChecksumAlgorithm: checksum.CRC64,
+	}
+
+	// resealed changes a header byte and recomputes the header checksum
+	resealed := func(offset int, value byte) []byte {
+		data := valid.Marshal()
+		data[offset] = value
+
+		copy(data[60:64], checksum.HeaderSum(data[:60]))
+
+		return data
+	}
+
+	tests := []struct {
+		name  string
+		data  []byte
+		magic bool // errMagicNotFound expected
+	}{
+		{"truncated", valid.Marshal()[:40], false},
+		{"no magic string", make([]byte, 64), true},
+		{"legacy magic string", append([]byte("YTDSv3"), make([]byte, 58)...), true},
+		{"damaged", func() []byte { data := valid.Marshal(); data[25] ^= 0x10; return data }(), false},
+		{"unknown version", resealed(6, 5), false},
+		{"legacy checksum", resealed(48, byte(checksum.LegacyCRC64)), false},
+		{"unknown checksum", resealed(48, 9), false},
+		{"unsupported bits per pixel", resealed(9, 3), false},
+		{"unknown compression", resealed(15, 0x7f), false},
+		{"chunk larger than the payload", func() []byte {
+			data := valid.Marshal()
+			binary.BigEndian.PutUint32(data[32:36], 1<<20)
+			copy(data[60:64], checksum.HeaderSum(data[:60]))
+
+			return data
+		}(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseHeader(tt.data)
+			if err == nil {
+				t.Fatal("invalid header accepted")
+			}
+
+			if errors.Is(err, errMagicNotFound) != tt.magic {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestMajorityVote(t *testing.T) {
+	var (
+		header = Header{Layout: Layout{Width: 1280, Height: 720, BitsPerPixel: 1, BlockSize: 1}, ChecksumAlgorithm: checksum.CRC32C}
+		copies = [][]byte{header.Marshal(), header.Marshal(), header.Marshal()}
+	)
+
+	// every copy is damaged at a different place
+	copies[0][0] ^= 0xFF
+	copies[1][30] ^= 0x01
+	copies[2][63] ^= 0x80
+
+	for _, c := range copies {
+		if _, err := ParseHeader(c); err == nil {
+			t.Fatal("damaged copy accepted")
+		}
+	}
+
+	if parsed, err := ParseHeader(majorityVote(copies)); err != nil || parsed != header {
+		t.Fatalf("vote not restored: %v", err)
+	}
+}
@@ -0,0 +1,289 @@
+package frame
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"github.com/sabouaram/data2vid/internal/constants"
+	"github.com/sabouaram/data2vid/internal/fec"
+)
+
+// ColorMode selects which image channels carry payload symbols
+type ColorMode uint8
+
+const (
+	// ColorGray stores one symbol per cell in a grayscale frame
+	ColorGray ColorMode = iota
+
+	// ColorRGB stores three independent symbols per cell in the red, green and blue channels
+	ColorRGB
+)
+
+// ParseColorMode converts a config value ("gray" or "rgb") to a ColorMode
+func ParseColorMode(mode string) (ColorMode, error) {
+	switch strings.ToLower(mode) {
+	case "", "gray":
+		return ColorGray, nil
+	case "rgb":
+		return ColorRGB, nil
+	}
+
+	return ColorGray, fmt.Errorf("unsupported color mode %q (expected gray or rgb)", mode)
+}
+
+// Channels returns the number of symbols carried by each payload cell
+func (m ColorMode) Channels() int {
+	if m == ColorRGB {
+		return 3
+	}
+
+	return 1
+}
+
+// RawPixelFormat returns the ffmpeg pixel format of the raw frames written for the color mode
+func (m ColorMode) RawPixelFormat() string {
+	if m == ColorRGB {
+		return "rgb24"
+	}
+
+	return "gray"
+}
+
+func (m ColorMode) String() string {
+	if m == ColorRGB {
+		return "rgb"
+	}
+
+	return "gray"
+}
+
+// Layout describes the frame geometry and the modulation used to map bytes onto pixels.
+//
+// The frame is split into square cells of BlockSize x BlockSize pixels, filled row by row around
+// the finder patterns of its corners, and every cell carries one symbol per color channel. Pixels
+// left over on the right and bottom edges (when the frame size is not a multiple of BlockSize)
+// stay white.
+type Layout struct {
+	Width        int
+	Height       int
+	BitsPerPixel int
+	BlockSize    int
+	ColorMode    ColorMode
+
+	// Reed-Solomon parity symbols per codeword (0 disables forward error correction)
+	Parity int
+
+	// cross-frame erasure coding: ParityFrames parity frames after every GroupSize data frames
+	// (0 parity frames disables it)
+	GroupSize    int
+	ParityFrames int
+}
+
+// Validate checks that the layout can hold a header and uses a supported modulation
+func (l Layout) Validate() error {
+	switch l.BitsPerPixel {
+	case 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("unsupported bits per pixel %d (expected 1, 2, 4 or 8)", l.BitsPerPixel)
+	}
+
+	if l.ColorMode != ColorGray && l.ColorMode != ColorRGB {
+		return fmt.Errorf("unsupported color mode %d", l.ColorMode)
+	}
+
+	if l.Width <= 0 || l.Height <= 0 {
+		return fmt.Errorf("invalid frame dimensions (%dx%d)", l.Width, l.Height)
+	}
+
+	if l.Width > math.MaxUint16 || l.Height > math.MaxUint16 {
+		return fmt.Errorf("frame dimensions (%dx%d) exceed %d pixels", l.Width, l.Height, math.MaxUint16)
+	}
+
+	if l.BlockSize <= 0 || l.BlockSize > constants.MaxBlockSize || l.BlockSize > l.Width || l.BlockSize > l.Height {
+		return fmt.Errorf("invalid block size %d for a %dx%d frame (at most %d)", l.BlockSize, l.Width, l.Height, constants.MaxBlockSize)
+	}
+
+	if l.Columns() <= 2*constants.FinderSize || l.Rows() <= 2*constants.FinderSize {
+		return fmt.Errorf("frame %dx%d with %dx%d blocks is too small for finder patterns",
+			l.Width, l.Height, l.BlockSize, l.BlockSize)
+	}
+
+	if l.Parity < 0 || l.Parity >= fec.MaxCodewordSize {
+		return fmt.Errorf("invalid parity symbol count %d (expected 0 to %d)", l.Parity, fec.MaxCodewordSize-1)
+	}
+
+	if l.ParityFrames < 0 || (l.ParityFrames > 0 && (l.GroupSize <= 0 || l.GroupSize+l.ParityFrames > fec.MaxCodewordSize)) {
+		return fmt.Errorf("invalid parity group (%d data + %d parity frames, at most %d in total)",
+			l.GroupSize, l.ParityFrames, fec.MaxCodewordSize)
+	}
+
+	if l.PayloadSize() <= 0 {
+		return fmt.Errorf("frame %dx%d with %dx%d blocks is too small to carry a payload",
+			l.Width, l.Height, l.BlockSize, l.BlockSize)
+	}
+
+	return nil
+}
+
+// Columns returns the number of cells in a frame row
+func (l Layout) Columns() int {
+	return l.Width / l.BlockSize
+}
+
+// Rows returns the number of cell rows in a frame
+func (l Layout) Rows() int {
+	return l.Height / l.BlockSize
+}
+
+// Cells returns the number of cells carrying the header copies and the body of a frame,
+// all of them but the finder patterns
+func (l Layout) Cells() int {
+	return l.Columns()*l.Rows() - 4*constants.FinderSize*constants.FinderSize
+}
+
+// cellPosition returns the column and row of a cell, cells being numbered row by row around
+// the finder patterns
+func (l Layout) cellPosition(cell int) (int, int) {
+	var (
+		columns = l.Columns()
+		size    = constants.FinderSize
+
+		// cells between the finder patterns of the top and bottom rows
+		band   = columns - 2*size
+		top    = size * band
+		middle = (l.Rows() - 2*size) * columns
+	)
+
+	switch {
+	case cell < top:
+		return size + cell%band, cell / band
+	case cell < top+middle:
+		cell -= top
+
+		return cell % columns, size + cell/columns
+	}
+
+	cell -= top + middle
+
+	return size + cell%band, l.Rows() - size + cell/band
+}
+
+// rowEnd returns the column following the last cell of a row: the finder patterns of the
+// top and bottom rows come first
+func (l Layout) rowEnd(row int) int {
+	if row < constants.FinderSize || row >= l.Rows()-constants.FinderSize {
+		return l.Columns() - constants.FinderSize
+	}
+
+	return l.Columns()
+}
+
+// finderModule reports whether the cell at column, row belongs to a finder pattern, and whether
+// it is black: a 3x3 black centre in a white ring, in a black ring, in the white border
+func (l Layout) finderModule(column, row int) (finder bool, black bool) {
+	var (
+		size    = constants.FinderSize
+		columns = l.Columns()
+		rows    = l.Rows()
+	)
+
+	if (column >= size && column < columns-size) || (row >= size && row < rows-size) {
+		return false, false
+	}
+
+	// position in the corner square
+	x, y := column, row
+
+	if column >= size {
+		x -= columns - size
+	}
+
+	if row >= size {
+		y -= rows - size
+	}
+
+	// rings around the centre of the corner square
+	ring := max(abs(x-size/2), abs(y-size/2))
+
+	return true, ring != 2 && ring != 4
+}
+
+// BodySize returns the number of bytes carried by the cells around the header copies.
+// The header is always written one bit per cell, the body uses BitsPerPixel bits per
+// cell and per color channel.
+func (l Layout) BodySize() int {
+	return ((l.Cells() - len(l.headerCells())*l.headerSpan()) * l.BitsPerPixel * l.ColorMode.Channels()) / 8
+}
+
+// headerSpan returns the cells of every header copy: one per header bit, followed by the
+// calibration patch
+func (l Layout) headerSpan() int {
+	return constants.HeaderSize*8 + constants.CalibrationLevels*constants.CalibrationCellsPerLevel
+}
+
+// headerCells returns the first cell of every header copy, in cell order: the top of the frame,
+// then the first cell row of the middle of the frame and the last cells of the frame (frames too
+// small for three copies keep the top one). They only depend on the frame geometry, so that the
+// decoder finds them before reading any header.
+func (l Layout) headerCells() []int {
+	var (
+		span   = l.headerSpan()
+		bottom = l.Cells() - span
+	)
+
+	if bottom < 2*span {
+		return []int{0}
+	}
+
+	// the middle copy stays clear of the top and bottom ones on small frames, the finder
+	// patterns of the top rows come before the middle row
+	middle := (l.Rows()/2)*l.Columns() - 2*constants.FinderSize*constants.FinderSize
+	middle = min(max(middle, span), bottom-span)
+
+	return []int{0, middle, bottom}
+}
+
+// PayloadSize returns the maximum number of payload bytes a single frame can carry:
+// the whole body, minus the Reed-Solomon parity symbols when error correction is enabled
+func (l Layout) PayloadSize() int {
+	if l.Parity == 0 || l.BodySize() <= 0 {
+		return l.BodySize()
+	}
+
+	return fec.DataSize(l.BodySize(), l.Parity)
+}
+
+// Overhead returns the share of the frame body spent on error correction parity
+func (l Layout) Overhead() float64 {
+	if l.BodySize() <= 0 {
+		return 0
+	}
+
+	return 1 - float64(l.PayloadSize())/float64(l.BodySize())
+}
+
+// cellRect returns the pixels covered by the cell at the given position (see cellPosition)
+func (l Layout) cellRect(cell int) image.Rectangle {
+	column, row := l.cellPosition(cell)
+	x, y := column*l.BlockSize, row*l.BlockSize
+
+	return image.Rect(x, y, x+l.BlockSize, y+l.BlockSize)
+}
+
+// sampleRect returns the centre of a cell used by the decoder: block edges are the
+// first pixels smeared by lossy codecs so only the inner half of the block is averaged
+func (l Layout) sampleRect(cell int) image.Rectangle {
+	margin := l.BlockSize / 4
+
+	return l.cellRect(cell).Inset(margin)
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+
+	return v
+}
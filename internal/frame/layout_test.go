@@ -0,0 +1,48 @@
+package frame
+
+import "testing"
+
+func TestParseColorMode(t *testing.T) {
+	tests := []struct {
+		value string
+		mode  ColorMode
+		valid bool
+	}{
+		{"", ColorGray, true},
+		{"gray", ColorGray, true},
+		{"RGB", ColorRGB, true},
+		{"cmyk", ColorGray, false},
+	}
+
+	for _, tt := range tests {
+		mode, err := ParseColorMode(tt.value)
+
+		if (err == nil) != tt.valid || (tt.valid && mode != tt.mode) {
+			t.Errorf("%q: got %v (%v)", tt.value, mode, err)
+		}
+	}
+}
+
+func TestLayoutValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout Layout
+		valid  bool
+	}{
+		{"gray", Layout{Width: 160, Height: 120, BitsPerPixel: 1, BlockSize: 1}, true},
+		{"rgb blocks", Layout{Width: 160, Height: 120, BitsPerPixel: 8, BlockSize: 4, ColorMode: ColorRGB}, true},
+		{"bits per pixel", Layout{Width: 160, Height: 120, BitsPerPixel: 3, BlockSize: 1}, false},
+		{"color mode", Layout{Width: 160, Height: 120, BitsPerPixel: 1, BlockSize: 1, ColorMode: 2}, false},
+		{"no block size", Layout{Width: 160, Height: 120, BitsPerPixel: 1}, false},
+		{"block larger than the frame", Layout{Width: 160, Height: 120, BitsPerPixel: 1, BlockSize: 121}, false},
+		{"parity", Layout{Width: 160, Height: 120, BitsPerPixel: 1, BlockSize: 1, Parity: 32}, true},
+		{"parity filling the codewords", Layout{Width: 160, Height: 120, BitsPerPixel: 1, BlockSize: 1, Parity: 255}, false},
+		{"no room for a payload", Layout{Width: 16, Height: 16, BitsPerPixel: 1, BlockSize: 1}, false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.layout.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+}
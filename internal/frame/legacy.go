@@ -0,0 +1,142 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/sabouaram/data2vid/internal/checksum"
+	"github.com/sabouaram/data2vid/internal/constants"
+	"github.com/sabouaram/data2vid/internal/types"
+)
+
+// processLegacyFrame extracts data from a legacy YTDSv3 frame: one bit per pixel, black & white
+// read with the threshold of the frame, in both polarities.
+//
+// header
+// +-------------+-------------+-------------+-------------+-------------+------------+
+// | Magic String|  Total Size | Sequence #  | Chunk Size  |    Data     |  Header    |
+// | (6 bytes)   |  (8 bytes)  | (4 bytes)   | (4 bytes)   |  Checksum   |  Checksum  |
+// |             |             |             |             |  (8 bytes)  |  (2 bytes) |
+// +-------------+-------------+-------------+-------------+-------------+-------------+
+// | 0     5     | 6        13 | 14      17  | 18      21  | 22       29 | 30      31  |
+// +-------------+-------------+-------------+-------------+-------------+-------------+
+func processLegacyFrame(p plane, threshold uint8) (types.Frame, error) {
+
+	var (
+		err       error
+		header    []byte
+		headerEnd int
+		data      = legacyBytes(p, binarySymbols(threshold, false))
+	)
+
+	// the bytes of an inverted frame are the complement of the ones read
+	for _, inverted := range []bool{false, true} {
+		if inverted {
+			for i := range data {
+				data[i] = ^data[i]
+			}
+		}
+
+		// header written at the first pixel, or searched over the whole frame (resynchronisation)
+		if isLegacyHeader(data[:min(constants.LegacyHeaderSize, len(data))]) {
+			header, headerEnd, err = data[:constants.LegacyHeaderSize], constants.LegacyHeaderSize, nil
+		} else if header, headerEnd, err = findLegacyHeader(data); err != nil {
+			continue
+		}
+
+		break
+	}
+
+	if err != nil {
+		return types.Frame{}, err
+	}
+
+	// parse metadata
+	totalSize := binary.BigEndian.Uint64(header[6:14])
+	sequence := int(binary.BigEndian.Uint32(header[14:18]))
+	chunkSize := binary.BigEndian.Uint32(header[18:22])
+	storedChecksum := binary.BigEndian.Uint64(header[22:30])
+
+	// validate chunk size against the pixels left after the header
+	if int(chunkSize) > len(data)-headerEnd {
+		return types.Frame{}, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+
+	// extract payload
+	payload := bytes.Clone(data[headerEnd : headerEnd+int(chunkSize)])
+
+	if checksum.LegacyCRC64.Sum(payload) != storedChecksum {
+		return types.Frame{}, errors.New("payload checksum mismatch")
+	}
+
+	return types.Frame{
+		Kind:      types.KindData,
+		Sequence:  sequence,
+		TotalSize: totalSize,
+		Payload:   payload,
+		Capacity:  len(data) - constants.LegacyHeaderSize,
+	}, nil
+}
+
+// legacyBytes returns the bytes of a legacy frame, one bit per pixel row by row (black is 1)
+func legacyBytes(p plane, binary *symbolTable) []byte {
+	var (
+		data        = make([]byte, 0, p.width*p.height/8)
+		currentByte byte
+		bitCount    = 0
+	)
+
+	for y := range p.height {
+		for x := range p.width {
+			currentByte = currentByte<<1 | binary[0][p.pixel(x, y)]
+
+			if bitCount++; bitCount == 8 {
+				data = append(data, currentByte)
+
+				currentByte = 0
+				bitCount = 0
+			}
+		}
+	}
+
+	return data
+}
+
+// isLegacyHeader reports whether data is a complete YTDSv3 header with a valid checksum
+func isLegacyHeader(data []byte) bool {
+	return len(data) == constants.LegacyHeaderSize &&
+		bytes.HasPrefix(data, []byte(constants.LegacyMagicString)) &&
+		bytes.Equal(checksum.ComputeChecksum(data[:30])[:2], data[30:32])
+}
+
+// findLegacyHeader returns the first valid header found in the bytes of a frame, and the byte
+// following it. Headers are byte aligned, corrupted ones are skipped.
+func findLegacyHeader(data []byte) ([]byte, int, error) {
+	var (
+		magic = []byte(constants.LegacyMagicString)
+		pos   = 0
+	)
+
+	if !bytes.Contains(data, magic) {
+		return nil, 0, errors.New("magic string not found")
+	}
+
+	for {
+		i := bytes.Index(data[pos:], magic)
+		if i == -1 {
+			break
+		}
+
+		pos += i
+
+		if header := data[pos:min(pos+constants.LegacyHeaderSize, len(data))]; isLegacyHeader(header) {
+			return header, pos + constants.LegacyHeaderSize, nil
+		}
+
+		pos++
+	}
+
+	return nil, 0, errors.New("header checksum mismatch")
+}
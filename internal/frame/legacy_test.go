@@ -0,0 +1,59 @@
+package frame
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"testing"
+
+	"github.com/sabouaram/data2vid/internal/types"
+)
+
+// testdata/ytdsv3.png is frame 3 of a 4096 bytes file, rendered at 320x240 by the first
+// release of the encoder (one bit per pixel, 32 bytes header at the first pixel)
+func TestLegacyGoldenFrame(t *testing.T) {
+	file, err := os.Open("testdata/ytdsv3.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	img, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		payload  = bytes.Repeat([]byte("YTDSv3 golden frame written by the first data2vid release. "), 20)
+		inverted = image.NewGray(img.Bounds())
+	)
+
+	for i, v := range img.(*image.Gray).Pix {
+		inverted.Pix[i] = 255 - v
+	}
+
+	for _, tt := range []struct {
+		name string
+		img  image.Image
+	}{
+		{"golden", img},
+		{"inverted", inverted},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := ProcessFrame(tt.img)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if frame.Kind != types.KindData || frame.Sequence != 3 || frame.TotalSize != 4096 {
+				t.Fatalf("got %v frame %d of %d bytes", frame.Kind, frame.Sequence, frame.TotalSize)
+			}
+
+			if !bytes.Equal(frame.Payload, payload) {
+				t.Fatal("payload not restored")
+			}
+		})
+	}
+}
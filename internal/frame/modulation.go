@@ -0,0 +1,60 @@
+package frame
+
+// Symbols are Gray-coded before being mapped onto evenly spaced gray levels,
+// so neighbouring levels only differ by one bit:
+//
+//	bits per pixel | levels | level spacing
+//	---------------+--------+--------------
+//	       1       |    2   |     255
+//	       2       |    4   |      85
+//	       4       |   16   |      17
+//	       8       |  256   |       1
+//
+// Level 0 is white and the last level is black, so 1 bit per pixel keeps the
+// original mapping (0 -> white, 1 -> black). The decoder slices the levels
+// learnt from every frame (see calibrate).
+
+// grayEncode returns the Gray code of v
+func grayEncode(v byte) byte {
+	return v ^ (v >> 1)
+}
+
+// grayDecode returns the binary value of the Gray code g
+func grayDecode(g byte) byte {
+	v := g
+
+	for shift := g >> 1; shift != 0; shift >>= 1 {
+		v ^= shift
+	}
+
+	return v
+}
+
+// symbolToGray maps a symbol of `bits` bits to its 8 bit gray intensity
+func symbolToGray(symbol byte, bits int) uint8 {
+	maxLevel := (1 << bits) - 1
+	level := int(grayDecode(symbol))
+
+	return uint8(255 - (level*255)/maxLevel)
+}
+
+// grayLevels returns the gray intensity of every symbol of `bits` bits, indexed by symbol
+func grayLevels(bits int) [256]uint8 {
+	var levels [256]uint8
+
+	for symbol := range 1 << bits {
+		levels[symbol] = symbolToGray(byte(symbol), bits)
+	}
+
+	return levels
+}
+
+// symbolAt returns the symbol of `bits` bits at the given index of data, most significant bits first
+func symbolAt(data []byte, index, bits int) byte {
+	var (
+		bit   = index * bits
+		shift = 8 - bits - bit%8
+	)
+
+	return (data[bit/8] >> shift) & (byte(1<<bits) - 1)
+}
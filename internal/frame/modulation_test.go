@@ -0,0 +1,79 @@
+package frame
+
+import (
+	"bytes"
+	"math/bits"
+	"testing"
+
+	"github.com/sabouaram/data2vid/internal/constants"
+)
+
+func TestGrayCode(t *testing.T) {
+	for v := range 256 {
+		if decoded := grayDecode(grayEncode(byte(v))); decoded != byte(v) {
+			t.Fatalf("%d decoded as %d", v, decoded)
+		}
+
+		// neighbouring levels only differ by one bit
+		if v > 0 && bits.OnesCount8(grayEncode(byte(v))^grayEncode(byte(v-1))) != 1 {
+			t.Fatalf("levels %d and %d differ by more than one bit", v-1, v)
+		}
+	}
+}
+
+func TestSymbolLevels(t *testing.T) {
+	for _, bitsPerPixel := range []int{1, 2, 4, 8} {
+		var (
+			count   = 1 << bitsPerPixel
+			spacing = 255 / (count - 1)
+			anchors [constants.CalibrationLevels][3]float64
+		)
+
+		// levels of an untouched frame
+		for k := range anchors {
+			anchors[k][0] = float64(calibrationLevel(k))
+		}
+
+		table := nearestSymbols(&anchors, bitsPerPixel, 1)
+
+		if symbolToGray(0, bitsPerPixel) != 255 || symbolToGray(grayEncode(byte(count-1)), bitsPerPixel) != 0 {
+			t.Fatalf("%d bits: symbols do not span white to black", bitsPerPixel)
+		}
+
+		for symbol := range count {
+			gray := int(symbolToGray(byte(symbol), bitsPerPixel))
+
+			// levels drifting by less than half the spacing are sliced back to the symbol
+			for _, drift := range []int{0, -(spacing - 1) / 2, (spacing - 1) / 2} {
+				level := min(255, max(0, gray+drift))
+
+				if sliced := table[0][level]; sliced != byte(symbol) {
+					t.Fatalf("%d bits: symbol %d at level %d sliced as %d", bitsPerPixel, symbol, level, sliced)
+				}
+			}
+		}
+	}
+}
+
+func TestSymbolAt(t *testing.T) {
+	tests := []struct {
+		bits     int
+		expected []byte
+	}{
+		{1, []byte{1, 0, 1, 1, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}},
+		{2, []byte{2, 3, 1, 0, 0, 0, 0, 1}},
+		{4, []byte{0xB, 0x4, 0x0, 0x1}},
+		{8, []byte{0xB4, 0x01}},
+	}
+
+	for _, tt := range tests {
+		symbols := make([]byte, len(tt.expected))
+		for i := range symbols {
+			symbols[i] = symbolAt([]byte{0xB4, 0x01}, i, tt.bits)
+		}
+
+		if !bytes.Equal(symbols, tt.expected) {
+			t.Errorf("%d bits: got %v, expected %v", tt.bits, symbols, tt.expected)
+		}
+	}
+}
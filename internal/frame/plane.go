@@ -0,0 +1,140 @@
+package frame
+
+import (
+	"image"
+	"image/draw"
+)
+
+// plane gives direct access to the 8 bit samples of a frame: the gray plane of gray frames,
+// interleaved R, G, B, A samples otherwise. Coordinates are relative to the frame bounds.
+type plane struct {
+	pix    []byte
+	stride int
+	step   int
+	width  int
+	height int
+}
+
+// newPlane returns the samples of a frame, used in place for gray and RGBA images,
+// other image types are converted to RGBA first
+func newPlane(img image.Image) plane {
+	var (
+		bounds = img.Bounds()
+		p      = plane{width: bounds.Dx(), height: bounds.Dy()}
+	)
+
+	switch img := img.(type) {
+	case *image.Gray:
+		p.pix, p.stride, p.step = img.Pix[img.PixOffset(bounds.Min.X, bounds.Min.Y):], img.Stride, 1
+	case *image.RGBA:
+		p.pix, p.stride, p.step = img.Pix[img.PixOffset(bounds.Min.X, bounds.Min.Y):], img.Stride, 4
+	default:
+		rgba := image.NewRGBA(image.Rect(0, 0, p.width, p.height))
+		draw.Draw(rgba, rgba.Rect, img, bounds.Min, draw.Src)
+
+		p.pix, p.stride, p.step = rgba.Pix, rgba.Stride, 4
+	}
+
+	return p
+}
+
+// gray returns the average 8 bit gray value of the pixels of rect,
+// the mean of the R, G and B samples of color frames
+func (p plane) gray(rect image.Rectangle) uint8 {
+	var (
+		sum    uint32
+		pixels uint32
+	)
+
+	// single pixel of a gray frame
+	if p.step == 1 && rect.Dx() == 1 && rect.Dy() == 1 {
+		return p.pix[rect.Min.Y*p.stride+rect.Min.X]
+	}
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		row := p.pix[y*p.stride:]
+
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if p.step == 1 {
+				sum += uint32(row[x]) * 0x101
+			} else {
+				i := x * p.step
+				sum += (uint32(row[i]) + uint32(row[i+1]) + uint32(row[i+2])) * 0x101 / 3
+			}
+
+			pixels++
+		}
+	}
+
+	return uint8((sum / pixels) >> 8)
+}
+
+// levels returns the average 8 bit level of the R, G and B samples of the pixels of rect,
+// gray frames have the same level on every channel
+func (p plane) levels(rect image.Rectangle) [3]uint8 {
+	var (
+		sum    [3]uint32
+		pixels uint32
+	)
+
+	if p.step == 1 {
+		gray := p.gray(rect)
+
+		return [3]uint8{gray, gray, gray}
+	}
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		row := p.pix[y*p.stride:]
+
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			i := x * p.step
+
+			sum[0] += uint32(row[i])
+			sum[1] += uint32(row[i+1])
+			sum[2] += uint32(row[i+2])
+			pixels++
+		}
+	}
+
+	return [3]uint8{
+		uint8((sum[0] * 0x101 / pixels) >> 8),
+		uint8((sum[1] * 0x101 / pixels) >> 8),
+		uint8((sum[2] * 0x101 / pixels) >> 8),
+	}
+}
+
+// sample returns the levels of the pixels of rect as read by a layout with the given channels:
+// the gray value on every channel, or the R, G and B levels
+func (p plane) sample(rect image.Rectangle, channels int) [3]uint8 {
+	if channels == 1 {
+		gray := p.gray(rect)
+
+		return [3]uint8{gray, gray, gray}
+	}
+
+	return p.levels(rect)
+}
+
+// pixel returns the gray value of the pixel at x, y
+func (p plane) pixel(x, y int) uint8 {
+	i := y*p.stride + x*p.step
+
+	if p.step == 1 {
+		return p.pix[i]
+	}
+
+	return uint8((int(p.pix[i]) + int(p.pix[i+1]) + int(p.pix[i+2])) / 3)
+}
+
+// histogram returns the histogram of the gray values of one pixel out of 4 in both directions
+func (p plane) histogram() [256]int {
+	var histogram [256]int
+
+	for y := 0; y < p.height; y += 4 {
+		for x := 0; x < p.width; x += 4 {
+			histogram[p.pixel(x, y)]++
+		}
+	}
+
+	return histogram
+}
@@ -0,0 +1,161 @@
+package frame
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/sabouaram/data2vid/internal/signature"
+)
+
+// Sink receives the frames of a video in order as raw pixels (in the color mode RawPixelFormat),
+// typically streamed to the video encoder, and records the digest of every frame payload for signing.
+// A sink from NewSink renders the frames concurrently on a pool of workers and writes them in the
+// order they were appended, a zero Sink renders and writes them one at a time. Pixel buffers are
+// reused from frame to frame.
+type Sink struct {
+	Output  io.Writer
+	Frames  int
+	Digests []signature.Digest
+
+	// worker pool: jobs are rendered by the workers, pending holds their results in frame order
+	jobs    chan renderJob
+	pending chan chan renderResult
+	failed  chan struct{}
+	done    chan struct{}
+	closed  bool
+	err     error
+
+	// pixels of the frames rendered one at a time, or the buffers of the workers once written
+	pixels  []byte
+	buffers sync.Pool
+}
+
+type renderJob struct {
+	header Header
+	data   []byte
+	result chan renderResult
+}
+
+type renderResult struct {
+	pixels []byte
+	err    error
+}
+
+// NewSink returns a sink writing to output with a pool of workers rendering frames,
+// GOMAXPROCS workers when workers < 1. The sink must be closed.
+func NewSink(output io.Writer, workers int) *Sink {
+	sink := &Sink{Output: output}
+
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	if workers == 1 {
+		return sink
+	}
+
+	// at most workers frames rendering and workers rendered frames waiting to be written
+	sink.jobs = make(chan renderJob)
+	sink.pending = make(chan chan renderResult, workers)
+	sink.failed = make(chan struct{})
+	sink.done = make(chan struct{})
+
+	for range workers {
+		go sink.render()
+	}
+
+	go sink.write()
+
+	return sink
+}
+
+// Close waits for the appended frames to be written and stops the workers,
+// it returns the first rendering or write error
+func (s *Sink) Close() error {
+	if s.jobs == nil || s.closed {
+		return s.err
+	}
+
+	s.closed = true
+
+	close(s.jobs)
+	close(s.pending)
+
+	<-s.done
+
+	return s.err
+}
+
+// render renders the frames of the jobs until the sink is closed
+func (s *Sink) render() {
+	for job := range s.jobs {
+		var r renderResult
+
+		buffer, _ := s.buffers.Get().([]byte)
+
+		r.pixels, r.err = renderPixels(buffer, job.header, job.data)
+
+		job.result <- r
+	}
+}
+
+// write writes the rendered frames in order, after an error the remaining ones are dropped
+func (s *Sink) write() {
+	defer close(s.done)
+
+	for result := range s.pending {
+		r := <-result
+
+		if s.err == nil && r.err == nil {
+			if _, err := s.Output.Write(r.pixels); err != nil {
+				r.err = fmt.Errorf("frame write error: %w", err)
+			}
+		}
+
+		if r.pixels != nil {
+			s.buffers.Put(r.pixels)
+		}
+
+		if s.err != nil {
+			continue
+		}
+
+		if r.err != nil {
+			s.err = r.err
+			close(s.failed)
+		}
+	}
+}
+
+// appendFrame queues the next frame of the video and records its digest
+func appendFrame(sink *Sink, header Header, data []byte) error {
+	if sink.jobs == nil {
+		var err error
+
+		if sink.pixels, err = renderPixels(sink.pixels, header, data); err != nil {
+			return fmt.Errorf("frame creation failed: %w", err)
+		}
+
+		if _, err = sink.Output.Write(sink.pixels); err != nil {
+			return fmt.Errorf("frame creation failed: frame write error: %w", err)
+		}
+	} else {
+		// callers reuse their buffers
+		job := renderJob{header: header, data: append([]byte(nil), data...), result: make(chan renderResult, 1)}
+
+		select {
+		case sink.pending <- job.result:
+		case <-sink.failed:
+			return fmt.Errorf("frame creation failed: %w", sink.err)
+		}
+
+		sink.jobs <- job
+	}
+
+	sink.Frames++
+	sink.Digests = append(sink.Digests, signature.NewDigest(uint8(header.Kind), header.Sequence, data))
+
+	return nil
+}
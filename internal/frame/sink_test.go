@@ -0,0 +1,187 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/sabouaram/data2vid/internal/checksum"
+	"github.com/sabouaram/data2vid/internal/types"
+)
+
+// sinkFrame is a frame appended to a sink
+type sinkFrame struct {
+	header Header
+	data   []byte
+}
+
+// sinkFrames returns frames alternating large and small layouts, so that the workers of a pool
+// finish them out of order
+func sinkFrames(count int) []sinkFrame {
+	var (
+		large  = Layout{Width: 640, Height: 480, BitsPerPixel: 1, BlockSize: 1, Parity: 32}
+		small  = Layout{Width: 160, Height: 120, BitsPerPixel: 1, BlockSize: 1}
+		frames []sinkFrame
+	)
+
+	for i := range count {
+		layout := small
+
+		if i%4 == 0 {
+			layout = large
+		}
+
+		frames = append(frames, sinkFrame{
+			header: Header{Kind: types.KindData, Layout: layout, Sequence: uint32(i), ChecksumAlgorithm: checksum.CRC32C},
+			data:   testPayload(layout.PayloadSize())[:layout.PayloadSize()-i],
+		})
+	}
+
+	return frames
+}
+
+// failingWriter fails the writes after the first n ones
+type failingWriter struct {
+	bytes.Buffer
+	n int
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n == 0 {
+		return 0, errWriteFailed
+	}
+
+	w.n--
+
+	return w.Buffer.Write(p)
+}
+
+// waitGoroutines waits for the goroutines started by a test to exit
+func waitGoroutines(t *testing.T, count int) {
+	t.Helper()
+
+	for range 100 {
+		if runtime.NumGoroutine() <= count {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("%d goroutines left running, expected %d", runtime.NumGoroutine(), count)
+}
+
+func TestSinkOrder(t *testing.T) {
+	var (
+		frames   = sinkFrames(12)
+		expected bytes.Buffer
+		serial   = &Sink{Output: &expected}
+	)
+
+	for _, f := range frames {
+		if err := appendFrame(serial, f.header, f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, workers := range []int{1, 2, 4} {
+		var (
+			output     bytes.Buffer
+			goroutines = runtime.NumGoroutine()
+			sink       = NewSink(&output, workers)
+		)
+
+		for _, f := range frames {
+			// the appended buffers are reused by the callers
+			data := bytes.Clone(f.data)
+
+			if err := appendFrame(sink, f.header, data); err != nil {
+				t.Fatal(err)
+			}
+
+			clear(data)
+		}
+
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(output.Bytes(), expected.Bytes()) {
+			t.Fatalf("%d workers: frames not written in order", workers)
+		}
+
+		if sink.Frames != len(frames) || len(sink.Digests) != len(frames) || sink.Digests[5] != serial.Digests[5] {
+			t.Fatalf("%d workers: %d frames and %d digests recorded", workers, sink.Frames, len(sink.Digests))
+		}
+
+		waitGoroutines(t, goroutines)
+	}
+}
+
+func TestSinkErrors(t *testing.T) {
+	var (
+		frames = sinkFrames(40)
+
+		// the frame cannot be rendered: invalid parity
+		broken = sinkFrame{header: Header{Layout: Layout{Width: 160, Height: 120, BitsPerPixel: 1, BlockSize: 1, Parity: 300}}}
+	)
+
+	tests := []struct {
+		name   string
+		output *failingWriter
+		frames []sinkFrame
+
+		// frames written before the failure
+		written int
+		err     error
+	}{
+		{"write error", &failingWriter{n: 3}, frames, 3, errWriteFailed},
+		{"frame failing partway through", &failingWriter{n: len(frames)}, append(frames[:5:5], append([]sinkFrame{broken}, frames[5:]...)...), 5, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				goroutines = runtime.NumGoroutine()
+				sink       = NewSink(tt.output, 4)
+				expected   bytes.Buffer
+				appendErr  error
+			)
+
+			for _, f := range tt.frames {
+				if appendErr = appendFrame(sink, f.header, f.data); appendErr != nil {
+					break
+				}
+			}
+
+			// the frames following the failure are refused
+			if appendErr == nil {
+				t.Fatal("every frame appended")
+			}
+
+			err := sink.Close()
+			if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+				t.Fatalf("got %v, expected %v", err, tt.err)
+			}
+
+			for _, f := range tt.frames[:tt.written] {
+				pixels, err := renderPixels(nil, f.header, f.data)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				expected.Write(pixels)
+			}
+
+			if !bytes.Equal(tt.output.Bytes(), expected.Bytes()) {
+				t.Fatal("frames written after the failure")
+			}
+
+			waitGoroutines(t, goroutines)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package keys
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Encode returns the text form of a key: its prefix followed by the unpadded base64url
+// encoding of its bytes
+func Encode(prefix string, data []byte) string {
+	return prefix + base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Decode returns the bytes of a key encoded with prefix
+func Decode(s, prefix string) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(s), prefix)
+	if !ok {
+		return nil, fmt.Errorf("malformed key: expected %s prefix", prefix)
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("malformed key: %w", err)
+	}
+
+	return data, nil
+}
+
+// Scan parses every non empty, non comment line of a key file
+func Scan[K any](data []byte, parse func(string) (K, error)) ([]K, error) {
+	var (
+		keys    []K
+		scanner = bufio.NewScanner(bytes.NewReader(data))
+	)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, err := parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, scanner.Err()
+}
@@ -0,0 +1,69 @@
+package keys
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const prefix = "D2V-TEST-KEY-"
+
+func TestDecode(t *testing.T) {
+	data := []byte{0x00, 0xfb, 0xff, 0x10}
+
+	tests := []struct {
+		name  string
+		input string
+		ok    bool
+	}{
+		{"encoded", Encode(prefix, data), true},
+		{"surrounding spaces", "  " + Encode(prefix, data) + "\n", true},
+		{"other prefix", Encode("D2V-OTHER-KEY-", data), false},
+		{"no prefix", strings.TrimPrefix(Encode(prefix, data), prefix), false},
+		{"padded", Encode(prefix, data) + "==", false},
+		{"standard base64", prefix + "APv/EA", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode(tt.input, prefix)
+
+			switch {
+			case !tt.ok && err == nil:
+				t.Fatal("malformed key decoded")
+			case !tt.ok:
+			case err != nil:
+				t.Fatal(err)
+			case !bytes.Equal(got, data):
+				t.Fatalf("got %x, want %x", got, data)
+			}
+		})
+	}
+}
+
+func TestScan(t *testing.T) {
+	errBad := errors.New("bad key")
+
+	parse := func(line string) (string, error) {
+		if line == "bad" {
+			return "", errBad
+		}
+
+		return line, nil
+	}
+
+	keys, err := Scan([]byte("# created: today\n\n  first  \n# comment\nsecond\n"), parse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 2 || keys[0] != "first" || keys[1] != "second" {
+		t.Fatalf("got %q", keys)
+	}
+
+	keys, err = Scan([]byte("first\n\nbad\n"), parse)
+	if !errors.Is(err, errBad) || !strings.HasPrefix(err.Error(), "line 3:") || keys != nil {
+		t.Fatalf("got %q, %v", keys, err)
+	}
+}
@@ -0,0 +1,161 @@
+package manifest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/sabouaram/data2vid/internal/checksum"
+)
+
+// Manifest describes the original file carried by a video, it is stored as JSON in the
+// manifest frames written before the data frames
+type Manifest struct {
+	Name     string            `json:"name"`
+	Size     uint64            `json:"size"`
+	Mode     os.FileMode       `json:"mode"`
+	ModTime  time.Time         `json:"mtime"`
+	MIMEType string            `json:"mime_type"`
+	SHA256   string            `json:"sha256,omitempty"`
+	BLAKE2b  string            `json:"blake2b,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+
+	// the data is an archive described by the table of contents frames
+	Archive bool `json:"archive,omitempty"`
+}
+
+// FromFile builds the manifest of an open file: the file is read once to hash it and
+// rewound to its start for the encoding
+func FromFile(file *os.File, digest checksum.Digest, tags map[string]string) (Manifest, error) {
+	var (
+		info os.FileInfo
+		m    Manifest
+		err  error
+	)
+
+	if info, err = file.Stat(); err != nil {
+		return Manifest{}, fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	if m, err = FromReader(file, info.Name(), digest, tags); err != nil {
+		return Manifest{}, err
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return Manifest{}, fmt.Errorf("seek error: %w", err)
+	}
+
+	m.Mode = info.Mode().Perm()
+	m.ModTime = info.ModTime().UTC()
+
+	return m, nil
+}
+
+// FromReader builds the manifest of a data stream, read until EOF to hash it
+func FromReader(r io.Reader, name string, digest checksum.Digest, tags map[string]string) (Manifest, error) {
+	var (
+		err     error
+		m       Manifest
+		hash    = digest.New()
+		sniff   = make([]byte, 512)
+		n       int
+		written int64
+	)
+
+	// first bytes for content type detection
+	if n, err = io.ReadFull(r, sniff); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return Manifest{}, fmt.Errorf("read error: %w", err)
+	}
+
+	hash.Write(sniff[:n])
+
+	if written, err = io.Copy(hash, r); err != nil {
+		return Manifest{}, fmt.Errorf("read error: %w", err)
+	}
+
+	m = Manifest{
+		Name:     filepath.Base(name),
+		Size:     uint64(int64(n) + written),
+		Mode:     0644,
+		ModTime:  time.Now().UTC(),
+		MIMEType: mimeType(name, sniff[:n]),
+		Tags:     tags,
+	}
+
+	if digest == checksum.BLAKE2b {
+		m.BLAKE2b = hex.EncodeToString(hash.Sum(nil))
+	} else {
+		m.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	return m, nil
+}
+
+// Marshal encodes the manifest into its frame payload
+func (m Manifest) Marshal() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// Parse decodes a manifest frame payload
+func Parse(data []byte) (Manifest, error) {
+	var m Manifest
+
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	return m, nil
+}
+
+// Digest returns the digest algorithm of the file and its recorded hex value
+func (m Manifest) Digest() (checksum.Digest, string) {
+	if m.BLAKE2b != "" {
+		return checksum.BLAKE2b, m.BLAKE2b
+	}
+
+	return checksum.SHA256, m.SHA256
+}
+
+// VerifySum checks the size and digest of restored data hashed as it was written
+// (with the algorithm returned by Digest)
+func (m Manifest) VerifySum(size uint64, sum []byte) error {
+	if size != m.Size {
+		return fmt.Errorf("size mismatch: manifest records %d bytes, got %d", m.Size, size)
+	}
+
+	if digest, value := m.Digest(); hex.EncodeToString(sum) != value {
+		return fmt.Errorf("%s mismatch with the manifest", digest.Title())
+	}
+
+	return nil
+}
+
+// SafeName returns the recorded file name reduced to its base name, so a crafted manifest
+// cannot make the decoder write outside the output directory or print control characters to
+// the terminal ("" when unusable)
+func (m Manifest) SafeName() string {
+	name := filepath.Base(filepath.Clean("/" + strings.ReplaceAll(m.Name, `\`, "/")))
+
+	if name == "/" || name == "." || name == ".." || strings.ContainsFunc(name, unicode.IsControl) {
+		return ""
+	}
+
+	return name
+}
+
+// mimeType guesses the MIME type from the file extension, then from its first bytes
+func mimeType(name string, head []byte) string {
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		return t
+	}
+
+	return http.DetectContentType(head)
+}
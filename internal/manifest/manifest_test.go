@@ -0,0 +1,159 @@
+package manifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/sabouaram/data2vid/internal/checksum"
+)
+
+func TestSafeName(t *testing.T) {
+	tests := []struct {
+		name, safe string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"dir/report.pdf", "report.pdf"},
+		{"../../etc/passwd", "passwd"},
+		{"/etc/passwd", "passwd"},
+		{`..\..\windows\system.ini`, "system.ini"},
+		{"report.pdf/..", ""},
+		{"..", ""},
+		{"", ""},
+		{"/", ""},
+		{"name\nwith a newline", ""},
+		{"\x1b[31mred", ""},
+		{"tab\tname", ""},
+		{"résumé.txt", "résumé.txt"},
+	}
+
+	for _, tt := range tests {
+		if safe := (Manifest{Name: tt.name}).SafeName(); safe != tt.safe {
+			t.Errorf("%q: got %q, expected %q", tt.name, safe, tt.safe)
+		}
+	}
+}
+
+func TestFromFile(t *testing.T) {
+	var (
+		path = filepath.Join(t.TempDir(), "notes.txt")
+		data = []byte("some notes\n")
+		sum  = sha256.Sum256(data)
+	)
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	m, err := FromFile(file, checksum.SHA256, map[string]string{"project": "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Name != "notes.txt" || m.Size != uint64(len(data)) || m.Mode != 0600 || m.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+
+	if m.MIMEType != "text/plain; charset=utf-8" || m.Tags["project"] != "test" {
+		t.Fatalf("unexpected type %q or tags %v", m.MIMEType, m.Tags)
+	}
+
+	// the file is rewound for the encoding
+	if read, err := io.ReadAll(file); err != nil || string(read) != string(data) {
+		t.Fatalf("file not rewound: %v", err)
+	}
+
+	payload, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := Parse(payload)
+	if err != nil || parsed.Name != m.Name || parsed.SHA256 != m.SHA256 || !parsed.ModTime.Equal(m.ModTime) {
+		t.Fatalf("manifest not parsed back: %v", err)
+	}
+
+	if _, err = Parse([]byte("{")); err == nil {
+		t.Fatal("invalid manifest parsed")
+	}
+}
+
+func TestFromReader(t *testing.T) {
+	var (
+		data = bytes.Repeat([]byte{0, 1, 2, 3}, 1000)
+		sum  = sha256.Sum256(data)
+	)
+
+	m, err := FromReader(bytes.NewReader(data), "dir/backup.tar", checksum.SHA256, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Name != "backup.tar" || m.Size != uint64(len(data)) || m.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+
+	if m.MIMEType != "application/x-tar" {
+		t.Fatalf("unexpected type %q", m.MIMEType)
+	}
+
+	// BLAKE2b-256 replaces the SHA-256
+	blake := blake2b.Sum256(data)
+
+	if m, err = FromReader(bytes.NewReader(data), "backup.tar", checksum.BLAKE2b, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if digest, value := m.Digest(); digest != checksum.BLAKE2b || value != hex.EncodeToString(blake[:]) || m.SHA256 != "" {
+		t.Fatalf("unexpected digest %s %q", digest, value)
+	}
+
+	if err = m.VerifySum(uint64(len(data)), blake[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	// short streams are sniffed too
+	if m, err = FromReader(bytes.NewReader([]byte("%PDF-1.7")), "stdin", checksum.SHA256, nil); err != nil || m.Size != 8 || m.MIMEType != "application/pdf" {
+		t.Fatalf("unexpected manifest %+v (%v)", m, err)
+	}
+}
+
+func TestVerifySum(t *testing.T) {
+	var (
+		data  = []byte("some notes\n")
+		sum   = sha256.Sum256(data)
+		other = sha256.Sum256([]byte("Some notes\n"))
+		m     = Manifest{Size: uint64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+	)
+
+	tests := []struct {
+		name  string
+		size  uint64
+		sum   []byte
+		valid bool
+	}{
+		{"same data", uint64(len(data)), sum[:], true},
+		{"truncated", uint64(len(data) - 1), sum[:], false},
+		{"changed", uint64(len(data)), other[:], false},
+		{"no sum", uint64(len(data)), nil, false},
+	}
+
+	for _, tt := range tests {
+		if err := m.VerifySum(tt.size, tt.sum); (err == nil) != tt.valid {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package signature
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/sabouaram/data2vid/internal/keys"
+)
+
+const (
+	// text prefixes of the encoded keys
+	publicKeyPrefix = "D2V-SIGN-PUBLIC-KEY-"
+	secretKeyPrefix = "D2V-SIGN-SECRET-KEY-"
+
+	// size of a packed digest: kind (1), sequence (4), SHA-256 (32)
+	digestSize = 1 + 4 + sha256.Size
+
+	// signed statement prefix, so the signature cannot be replayed on other messages
+	context = "data2vid signature v1\x00"
+)
+
+var (
+	// ErrInvalidSignature is returned when the signature does not match its statement
+	ErrInvalidSignature = errors.New("invalid signature")
+
+	// ErrFrameMismatch is returned when decoded frames are not the signed ones
+	ErrFrameMismatch = errors.New("frames do not match the signature")
+)
+
+// PrivateKey is an Ed25519 key signing encoded videos
+type PrivateKey struct {
+	key ed25519.PrivateKey
+}
+
+// PublicKey is an Ed25519 key verifying video signatures
+type PublicKey struct {
+	key ed25519.PublicKey
+}
+
+// GenerateKey generates a random signing key
+func GenerateKey() (PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return PrivateKey{}, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	return PrivateKey{key: key}, nil
+}
+
+// Public returns the public key verifying the signatures of the key
+func (k PrivateKey) Public() PublicKey {
+	return PublicKey{key: k.key.Public().(ed25519.PublicKey)}
+}
+
+func (k PrivateKey) String() string {
+	return keys.Encode(secretKeyPrefix, k.key.Seed())
+}
+
+func (k PublicKey) String() string {
+	return keys.Encode(publicKeyPrefix, k.key)
+}
+
+// Equal reports whether both public keys are the same
+func (k PublicKey) Equal(other PublicKey) bool {
+	return k.key.Equal(other.key)
+}
+
+// ParsePrivateKey decodes a D2V-SIGN-SECRET-KEY- encoded signing key
+func ParsePrivateKey(s string) (PrivateKey, error) {
+	data, err := keys.Decode(s, secretKeyPrefix)
+	if err != nil {
+		return PrivateKey{}, err
+	}
+
+	if len(data) != ed25519.SeedSize {
+		return PrivateKey{}, errors.New("invalid signing key length")
+	}
+
+	return PrivateKey{key: ed25519.NewKeyFromSeed(data)}, nil
+}
+
+// ParsePublicKey decodes a D2V-SIGN-PUBLIC-KEY- encoded public key
+func ParsePublicKey(s string) (PublicKey, error) {
+	data, err := keys.Decode(s, publicKeyPrefix)
+	if err != nil {
+		return PublicKey{}, err
+	}
+
+	if len(data) != ed25519.PublicKeySize {
+		return PublicKey{}, errors.New("invalid public key length")
+	}
+
+	return PublicKey{key: ed25519.PublicKey(data)}, nil
+}
+
+// ParseKeyFile reads the signing key of a key file written by keygen: the first line
+// that is neither empty nor a # comment
+func ParseKeyFile(data []byte) (PrivateKey, error) {
+	found, err := keys.Scan(data, ParsePrivateKey)
+	if err != nil {
+		return PrivateKey{}, err
+	}
+
+	if len(found) != 1 {
+		return PrivateKey{}, fmt.Errorf("expected one signing key, found %d", len(found))
+	}
+
+	return found[0], nil
+}
+
+// ParsePublicKeys reads a file of public keys: one per line, # comments ignored
+func ParsePublicKeys(data []byte) ([]PublicKey, error) {
+	return keys.Scan(data, ParsePublicKey)
+}
+
+// Digest identifies one frame of a video: its stream, its sequence number in the stream and
+// the SHA-256 of its payload (the header checksum detects corruption, not forgery)
+type Digest struct {
+	Kind     uint8
+	Sequence uint32
+	Hash     [sha256.Size]byte
+}
+
+// NewDigest returns the digest of a frame payload
+func NewDigest(kind uint8, sequence uint32, payload []byte) Digest {
+	return Digest{Kind: kind, Sequence: sequence, Hash: sha256.Sum256(payload)}
+}
+
+// Signature is stored as JSON in the signature frames written last: the digests of every other
+// frame of the video (keys, manifest, table of contents, data and parity) signed together
+type Signature struct {
+	PublicKey []byte `json:"public_key"`
+	Frames    []byte `json:"frames"` // packed digests
+	Signature []byte `json:"signature"`
+}
+
+// Sign signs the digests of the frames of a video
+func Sign(key PrivateKey, digests []Digest) Signature {
+	frames := make([]byte, 0, len(digests)*digestSize)
+
+	for _, digest := range digests {
+		frames = append(frames, digest.Kind)
+		frames = binary.BigEndian.AppendUint32(frames, digest.Sequence)
+		frames = append(frames, digest.Hash[:]...)
+	}
+
+	return Signature{
+		PublicKey: key.Public().key,
+		Frames:    frames,
+		Signature: ed25519.Sign(key.key, append([]byte(context), frames...)),
+	}
+}
+
+// Marshal encodes the signature into its frame payload
+func (s Signature) Marshal() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// Parse decodes a signature frame payload
+func Parse(data []byte) (Signature, error) {
+	var s Signature
+
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("invalid signature: %w", err)
+	}
+
+	if len(s.PublicKey) != ed25519.PublicKeySize || len(s.Frames)%digestSize != 0 {
+		return s, errors.New("invalid signature: malformed public key or frame digests")
+	}
+
+	return s, nil
+}
+
+// Signer returns the public key the signature claims to be made with
+func (s Signature) Signer() PublicKey {
+	return PublicKey{key: ed25519.PublicKey(s.PublicKey)}
+}
+
+// Verify checks the signature against its signer public key and returns the set of signed digests
+func (s Signature) Verify() (map[Digest]bool, error) {
+	if !ed25519.Verify(s.PublicKey, append([]byte(context), s.Frames...), s.Signature) {
+		return nil, ErrInvalidSignature
+	}
+
+	digests := make(map[Digest]bool, len(s.Frames)/digestSize)
+
+	for frames := s.Frames; len(frames) > 0; frames = frames[digestSize:] {
+		digest := Digest{Kind: frames[0], Sequence: binary.BigEndian.Uint32(frames[1:5])}
+		copy(digest.Hash[:], frames[5:digestSize])
+
+		digests[digest] = true
+	}
+
+	return digests, nil
+}
@@ -0,0 +1,153 @@
+package signature
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"testing"
+)
+
+// testKey generates a signing key
+func testKey(t *testing.T) PrivateKey {
+	t.Helper()
+
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestSignVerify(t *testing.T) {
+	var (
+		key     = testKey(t)
+		digests = []Digest{
+			NewDigest(0, 0, []byte("first data frame")),
+			NewDigest(0, 1, []byte("second data frame")),
+			NewDigest(1, 0, []byte("parity frame")),
+		}
+	)
+
+	payload, err := Sign(key, digests).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := Parse(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !sig.Signer().Equal(key.Public()) {
+		t.Fatal("wrong signer")
+	}
+
+	signed, err := sig.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := map[Digest]bool{digests[0]: true, digests[1]: true, digests[2]: true}; !maps.Equal(signed, expected) {
+		t.Fatalf("got %d signed digests", len(signed))
+	}
+
+	// digests identify the stream, the sequence and the payload
+	for _, other := range []Digest{
+		NewDigest(0, 0, []byte("first data frame!")),
+		NewDigest(0, 2, []byte("second data frame")),
+		NewDigest(2, 1, []byte("second data frame")),
+	} {
+		if signed[other] {
+			t.Fatalf("digest %+v signed", other)
+		}
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	var (
+		key     = testKey(t)
+		digests = []Digest{NewDigest(0, 0, []byte("a")), NewDigest(0, 1, []byte("b"))}
+	)
+
+	tests := []struct {
+		name   string
+		modify func(s *Signature)
+	}{
+		{"modified digest", func(s *Signature) { s.Frames[10] ^= 1 }},
+		{"reordered digests", func(s *Signature) {
+			s.Frames = append(bytes.Clone(s.Frames[digestSize:]), s.Frames[:digestSize]...)
+		}},
+		{"dropped digest", func(s *Signature) { s.Frames = s.Frames[digestSize:] }},
+		{"modified signature", func(s *Signature) { s.Signature[0] ^= 1 }},
+		{"other signer", func(s *Signature) { s.PublicKey = testKey(t).Public().key }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig := Sign(key, digests)
+			sig.Frames, sig.Signature = bytes.Clone(sig.Frames), bytes.Clone(sig.Signature)
+
+			tt.modify(&sig)
+
+			if _, err := sig.Verify(); !errors.Is(err, ErrInvalidSignature) {
+				t.Fatalf("got %v, expected %v", err, ErrInvalidSignature)
+			}
+		})
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	sig := Sign(testKey(t), []Digest{NewDigest(0, 0, []byte("a"))})
+
+	for name, modify := range map[string]func(s *Signature){
+		"short public key": func(s *Signature) { s.PublicKey = s.PublicKey[1:] },
+		"partial digest":   func(s *Signature) { s.Frames = s.Frames[1:] },
+	} {
+		s := sig
+		modify(&s)
+
+		payload, err := s.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = Parse(payload); err == nil {
+			t.Errorf("%s: signature parsed", name)
+		}
+	}
+
+	if _, err := Parse([]byte("{")); err == nil {
+		t.Error("invalid JSON parsed")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	key := testKey(t)
+
+	parsed, err := ParseKeyFile([]byte("# signing key\n\n" + key.String() + "\n"))
+	if err != nil || parsed.String() != key.String() {
+		t.Fatalf("signing key not parsed: %v", err)
+	}
+
+	public, err := ParsePublicKeys([]byte(key.Public().String() + "\n# comment\n  " + testKey(t).Public().String() + "  \n"))
+	if err != nil || len(public) != 2 || !public[0].Equal(key.Public()) {
+		t.Fatalf("public keys not parsed: %v", err)
+	}
+
+	for _, invalid := range [][]byte{
+		nil,
+		[]byte(key.String() + "\n" + testKey(t).String()),
+		[]byte(key.Public().String()),
+		[]byte(key.String()[:30]),
+		[]byte(key.String() + "!"),
+	} {
+		if _, err = ParseKeyFile(invalid); err == nil {
+			t.Errorf("key file %q accepted", invalid)
+		}
+	}
+
+	if _, err = ParsePublicKey(key.String()); err == nil {
+		t.Error("signing key accepted as a public key")
+	}
+}
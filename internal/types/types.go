@@ -1,10 +1,154 @@
 package types
 
+import (
+	"image"
+	"io"
+
+	"github.com/sabouaram/data2vid/internal/archive"
+	"github.com/sabouaram/data2vid/internal/compress"
+	"github.com/sabouaram/data2vid/internal/encryption"
+	"github.com/sabouaram/data2vid/internal/manifest"
+	"github.com/sabouaram/data2vid/internal/signature"
+)
+
+// FrameKind identifies what a frame payload carries
+type FrameKind uint8
+
+const (
+	// KindData frames carry the file data
+	KindData FrameKind = iota
+
+	// KindParity frames carry the cross-frame erasure coding parity of a group of data frames
+	KindParity
+
+	// KindManifest frames carry the JSON manifest describing the original file
+	KindManifest
+
+	// KindTOC frames carry the JSON table of contents of an archive
+	KindTOC
+
+	// KindKeys frames carry the JSON encryption envelope holding the wrapped file keys
+	KindKeys
+
+	// KindSignature frames carry the JSON signature of the digests of every other frame
+	KindSignature
+)
+
 type Frame struct {
-	Sequence int
-	Payload  []byte
+	Kind      FrameKind
+	Sequence  int
+	TotalSize uint64
+	Payload   []byte
+
+	// compression and encryption of the stream the payload belongs to
+	Compression compress.Algorithm
+	Encrypted   bool
+
+	// number of data frames in the video (0 when unknown)
+	TotalFrames int
+
+	// symbols fixed by forward error correction
+	Corrected int
+
+	// data payload bytes carried by a full frame
+	Capacity int
+
+	// cross-frame erasure coding: each group of GroupSize data frames is followed by
+	// ParityFrames parity frames, Sequence is the parity frame index for KindParity
+	GroupSize    int
+	ParityFrames int
+}
+
+// DecodeReport summarizes a decoding run
+type DecodeReport struct {
+	Frames           int
+	ValidFrames      int
+	CorrectedSymbols int
+	RecoveredFrames  int
+
+	// written file, and the manifest read from the video (nil for videos without one)
+	OutputPath string
+	Manifest   *manifest.Manifest
+
+	// table of contents of archive videos, and the entries extracted from it
+	TOC       *archive.TOC
+	Extracted int
+
+	// the video was encrypted and decrypted with its passphrase
+	Encrypted bool
+
+	// public key the video is signed with (empty for unsigned videos), and whether it is one
+	// of the trusted keys
+	Signer  string
+	Trusted bool
+
+	// data that could not be restored, set by salvaging decodes of incomplete videos only
+	Damage *DamageMap
+
+	// signature or frame mismatch of a signed video decoded without trusted keys (with trusted
+	// keys the decoding fails instead), and signed frames neither decoded nor recovered
+	SignatureError error
+	MissingFrames  int
+}
+
+// DecodeOptions selects what a decoding run restores
+type DecodeOptions struct {
+	// glob patterns of the archive entries to extract (all entries when empty)
+	Patterns []string
+
+	// only read the manifest and table of contents, nothing is written
+	MetadataOnly bool
+
+	// called when the video is encrypted with a passphrase
+	Passphrase func() ([]byte, error)
+
+	// private keys tried on videos encrypted for recipients, before the passphrase
+	Identities []encryption.Identity
+
+	// public keys the video must be signed with: unsigned, untrusted or modified videos are refused
+	TrustedKeys []signature.PublicKey
+
+	// only check the signature, nothing is decrypted or written
+	VerifyOnly bool
+
+	// frame decoding workers, GOMAXPROCS when 0
+	Workers int
+
+	// incomplete videos are restored anyway: the data of missing frames is zero-filled and
+	// described by the report damage map, the manifest checksum is not checked
+	Salvage bool
+
+	// the restored file is written to Output instead of a file when set (not for archives)
+	Output io.Writer
+
+	// an existing file at the original file name is replaced, when no output path is given
+	Force bool
 }
 
+// FrameProcessor decodes one video frame image
 type FrameProcessor interface {
-	ProcessFrameWithSequence(string) ([]byte, uint64, int, error)
+	ProcessFrame(image.Image) (Frame, error)
+}
+
+// ByteRange is the byte range [Start, End) of a file
+type ByteRange struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+}
+
+// DamageMap describes the data a salvaging decode could not restore
+type DamageMap struct {
+	// data frames neither decoded nor recovered
+	MissingFrames []int `json:"missing_frames"`
+
+	// zero-filled byte ranges of the restored file
+	Ranges []ByteRange `json:"ranges"`
+
+	// size of the restored file, decompression stops at the first damaged byte of compressed
+	// videos: the rest of the file is lost (Ranges then runs to the original size when known)
+	Size      uint64 `json:"size"`
+	Truncated bool   `json:"truncated,omitempty"`
+
+	// archive entries overlapping the damaged ranges
+	DamagedEntries []string `json:"damaged_entries,omitempty"`
 }
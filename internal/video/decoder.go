@@ -0,0 +1,113 @@
+package video
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/sabouaram/data2vid/internal/frame"
+	"github.com/sabouaram/data2vid/internal/types"
+)
+
+// frameDecoder decodes the raw frames streamed from ffmpeg on a pool of workers and hands the
+// results to collect one at a time, in the order of the frames in the video
+type frameDecoder struct {
+	processor types.FrameProcessor
+	info      videoInfo
+	format    string
+	collect   func(types.Frame, error)
+
+	// jobs are decoded by the workers, pending holds their results in video order
+	jobs    chan decodeJob
+	pending chan chan decodeResult
+	done    chan struct{}
+	buffers sync.Pool
+}
+
+type decodeJob struct {
+	pixels []byte
+	result chan decodeResult
+}
+
+type decodeResult struct {
+	frame types.Frame
+	err   error
+}
+
+// newFrameDecoder starts workers decoding goroutines (GOMAXPROCS when workers < 1),
+// one worker decodes the frames in the calling goroutine
+func newFrameDecoder(processor types.FrameProcessor, info videoInfo, workers int, collect func(types.Frame, error)) *frameDecoder {
+	d := &frameDecoder{processor: processor, info: info, format: info.extractFormat(), collect: collect}
+
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	if workers == 1 {
+		return d
+	}
+
+	// at most workers frames decoding and workers decoded frames waiting to be collected
+	d.jobs = make(chan decodeJob)
+	d.pending = make(chan chan decodeResult, workers)
+	d.done = make(chan struct{})
+
+	for range workers {
+		go d.work()
+	}
+
+	go d.gather()
+
+	return d
+}
+
+// decode queues the raw pixels of the next frame, they are copied
+func (d *frameDecoder) decode(pixels []byte) {
+	if d.jobs == nil {
+		d.collect(d.processor.ProcessFrame(frame.RawImage(pixels, d.info.Width, d.info.Height, d.format)))
+
+		return
+	}
+
+	buffer, _ := d.buffers.Get().([]byte)
+	job := decodeJob{pixels: append(buffer[:0], pixels...), result: make(chan decodeResult, 1)}
+
+	d.pending <- job.result
+	d.jobs <- job
+}
+
+// close waits for the queued frames to be collected and stops the workers
+func (d *frameDecoder) close() {
+	if d.jobs == nil {
+		return
+	}
+
+	close(d.jobs)
+	close(d.pending)
+
+	<-d.done
+}
+
+// work decodes the frames of the jobs until the decoder is closed
+func (d *frameDecoder) work() {
+	for job := range d.jobs {
+		var r decodeResult
+
+		r.frame, r.err = d.processor.ProcessFrame(frame.RawImage(job.pixels, d.info.Width, d.info.Height, d.format))
+
+		// the payload never references the pixels
+		d.buffers.Put(job.pixels)
+
+		job.result <- r
+	}
+}
+
+// gather collects the decoded frames in order
+func (d *frameDecoder) gather() {
+	defer close(d.done)
+
+	for result := range d.pending {
+		r := <-result
+
+		d.collect(r.frame, r.err)
+	}
+}
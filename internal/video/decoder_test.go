@@ -0,0 +1,88 @@
+package video
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sabouaram/data2vid/internal/types"
+)
+
+// stubProcessor decodes 1x1 gray frames whose pixel is the frame sequence, the frames taking
+// longer to decode the lower their sequence modulo 4, and failing when the pixel is 0xff
+type stubProcessor struct{}
+
+var errStubFrame = errors.New("unreadable frame")
+
+func (stubProcessor) ProcessFrame(img image.Image) (types.Frame, error) {
+	sequence := int(img.(*image.Gray).Pix[0])
+
+	time.Sleep(time.Duration(3-sequence%4) * time.Millisecond)
+
+	if sequence == 0xff {
+		return types.Frame{}, errStubFrame
+	}
+
+	return types.Frame{Sequence: sequence}, nil
+}
+
+func TestFrameDecoderOrder(t *testing.T) {
+	var (
+		info = videoInfo{Width: 1, Height: 1, PixelFormat: "gray"}
+
+		// the sixth frame fails to decode
+		input = []int{0, 1, 2, 3, 4, 0xff, 5, 6, 7, 8, 9, 10, 11}
+	)
+
+	for _, workers := range []int{1, 2, 4} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			var (
+				goroutines = runtime.NumGoroutine()
+				collected  []int
+				pixels     = make([]byte, 1)
+			)
+
+			decoder := newFrameDecoder(stubProcessor{}, info, workers, func(f types.Frame, err error) {
+				if err != nil {
+					f.Sequence = 0xff
+				}
+
+				collected = append(collected, f.Sequence)
+			})
+
+			// the pixels are copied: the reader reuses its buffer
+			for _, pixel := range input {
+				pixels[0] = byte(pixel)
+				decoder.decode(pixels)
+			}
+
+			// the queued frames are collected before the workers stop
+			decoder.close()
+
+			if !slices.Equal(collected, input) {
+				t.Fatalf("frames collected in order %v", collected)
+			}
+
+			waitGoroutines(t, goroutines)
+		})
+	}
+}
+
+// waitGoroutines waits for the goroutines started by a test to exit
+func waitGoroutines(t *testing.T, count int) {
+	t.Helper()
+
+	for range 100 {
+		if runtime.NumGoroutine() <= count {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("%d goroutines left running, expected %d", runtime.NumGoroutine(), count)
+}
@@ -0,0 +1,57 @@
+package video
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	ffmpeg_go "github.com/u2takey/ffmpeg-go"
+)
+
+// videoInfo holds the properties of the video stream reported by ffprobe
+type videoInfo struct {
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	PixelFormat string `json:"pix_fmt"`
+	CodecType   string `json:"codec_type"`
+}
+
+// probeVideo returns the properties of the first video stream of a file
+func probeVideo(videoPath string) (videoInfo, error) {
+	var (
+		output string
+		err    error
+		probe  struct {
+			Streams []videoInfo `json:"streams"`
+		}
+	)
+
+	if output, err = ffmpeg_go.Probe(videoPath); err != nil {
+		return videoInfo{}, fmt.Errorf("ffprobe error: %w", err)
+	}
+
+	if err = json.Unmarshal([]byte(output), &probe); err != nil {
+		return videoInfo{}, fmt.Errorf("invalid ffprobe output: %w", err)
+	}
+
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "video" {
+			return stream, nil
+		}
+	}
+
+	return videoInfo{}, errors.New("no video stream found")
+}
+
+// extractFormat returns the pixel format frames are extracted with: RGB videos keep their
+// three channels, every other video only carries data in its luma plane
+func (v videoInfo) extractFormat() string {
+	for _, prefix := range []string{"gray", "yuv", "nv"} {
+		if strings.HasPrefix(v.PixelFormat, prefix) {
+			return "gray"
+		}
+	}
+
+	return "rgb24"
+}
@@ -0,0 +1,99 @@
+package video
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	ffmpeg_go "github.com/u2takey/ffmpeg-go"
+)
+
+// extractFrames streams the frames of a video from ffmpeg as raw pixels (in the extractFormat
+// pixel format) and calls process on each of them as it arrives, the pixels are only valid during
+// the call. Extraction stops early when process returns false. ffmpeg is run again without the
+// frame rate filter when it fails before the first frame.
+func extractFrames(videoPath string, info videoInfo, process func([]byte) bool) error {
+	var (
+		frames int
+		err    error
+	)
+
+	if info.Width <= 0 || info.Height <= 0 {
+		return fmt.Errorf("invalid video size %dx%d", info.Width, info.Height)
+	}
+
+	if frames, err = readFrames(videoPath, info, ffmpeg_go.KwArgs{"vsync": "0", "vf": "fps=1"}, process); err == nil || frames > 0 {
+		return err
+	}
+
+	// alternative
+	_, err = readFrames(videoPath, info, ffmpeg_go.KwArgs{"vsync": "0"}, process)
+
+	return err
+}
+
+// readFrames runs one ffmpeg extraction writing rawvideo frames to its stdout and returns the
+// number of frames read
+func readFrames(videoPath string, info videoInfo, kwArgs ffmpeg_go.KwArgs, process func([]byte) bool) (int, error) {
+	var (
+		format  = info.extractFormat()
+		frames  int
+		stopped bool
+		stdout  io.ReadCloser
+		err     error
+	)
+
+	kwArgs["f"] = "rawvideo"
+	kwArgs["pix_fmt"] = format
+
+	cmd := ffmpeg_go.Input(videoPath).Output("pipe:1", kwArgs).Compile()
+
+	if stdout, err = cmd.StdoutPipe(); err != nil {
+		return 0, fmt.Errorf("ffmpeg error: %w", err)
+	}
+
+	if err = cmd.Start(); err != nil {
+		return 0, fmt.Errorf("ffmpeg error: %w", err)
+	}
+
+	// one buffer reused for every frame
+	buffer := make([]byte, info.Width*info.Height*channels(format))
+
+	for {
+		if _, err = io.ReadFull(stdout, buffer); err != nil {
+			break
+		}
+
+		frames++
+
+		// no more frames needed: ffmpeg is stopped
+		if stopped = !process(buffer); stopped {
+			cmd.Process.Kill()
+
+			break
+		}
+	}
+
+	// a truncated last frame is dropped
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = nil
+	}
+
+	// drain so that ffmpeg can exit
+	io.Copy(io.Discard, stdout)
+
+	if waitErr := cmd.Wait(); err == nil && waitErr != nil && !stopped {
+		err = fmt.Errorf("ffmpeg error: %w", waitErr)
+	}
+
+	return frames, err
+}
+
+// channels returns the bytes per pixel of a raw pixel format
+func channels(pixelFormat string) int {
+	if pixelFormat == "rgb24" {
+		return 3
+	}
+
+	return 1
+}
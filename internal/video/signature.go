@@ -0,0 +1,88 @@
+package video
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/sabouaram/data2vid/internal/signature"
+	"github.com/sabouaram/data2vid/internal/types"
+)
+
+// verifySignature checks the signature frames of a video against the signer public key and the
+// trusted keys, and returns the signed frame digests (nil for unsigned videos)
+func verifySignature(signatureFrames map[int]types.Frame, opts types.DecodeOptions, report *types.DecodeReport) (map[signature.Digest]bool, error) {
+	var (
+		data    []byte
+		sig     signature.Signature
+		digests map[signature.Digest]bool
+		err     error
+	)
+
+	if len(signatureFrames) == 0 {
+		if len(opts.TrustedKeys) > 0 {
+			return nil, errors.New("the video is not signed")
+		}
+
+		return nil, nil
+	}
+
+	if data, err = assembleStream(signatureFrames); err != nil {
+		return nil, signatureFailure(fmt.Errorf("signature error: %w", err), opts, report)
+	}
+
+	if sig, err = signature.Parse(data); err != nil {
+		return nil, signatureFailure(err, opts, report)
+	}
+
+	if digests, err = sig.Verify(); err != nil {
+		return nil, signatureFailure(err, opts, report)
+	}
+
+	signer := sig.Signer()
+
+	report.Signer = signer.String()
+	report.Trusted = slices.ContainsFunc(opts.TrustedKeys, signer.Equal)
+
+	if len(opts.TrustedKeys) > 0 && !report.Trusted {
+		return nil, fmt.Errorf("the video is signed by an untrusted key: %s", signer)
+	}
+
+	return digests, nil
+}
+
+// checkFrames checks the digests of decoded frames against the signed digests, matched digests are
+// removed so that the remaining ones are the signed frames not found
+func checkFrames(digests map[signature.Digest]bool, frames []signature.Digest, opts types.DecodeOptions, report *types.DecodeReport) error {
+	var mismatches []int
+
+	for _, digest := range frames {
+		if !digests[digest] {
+			mismatches = append(mismatches, int(digest.Sequence))
+			continue
+		}
+
+		delete(digests, digest)
+	}
+
+	if len(mismatches) == 0 {
+		return nil
+	}
+
+	return signatureFailure(fmt.Errorf("%w: %d frames were modified or do not belong to the video (sequences %v)",
+		signature.ErrFrameMismatch, len(mismatches), mismatches), opts, report)
+}
+
+// signatureFailure fails the decoding when trusted keys are given, and only records the
+// failure in the report otherwise
+func signatureFailure(err error, opts types.DecodeOptions, report *types.DecodeReport) error {
+	if len(opts.TrustedKeys) > 0 {
+		return err
+	}
+
+	if report.SignatureError == nil {
+		report.SignatureError = err
+	}
+
+	return nil
+}
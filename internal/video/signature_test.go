@@ -0,0 +1,171 @@
+package video
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sabouaram/data2vid/internal/signature"
+	"github.com/sabouaram/data2vid/internal/types"
+)
+
+// testSigningKey generates a signing key
+func testSigningKey(t *testing.T) signature.PrivateKey {
+	t.Helper()
+
+	key, err := signature.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+// frameDigests returns the digests of frames
+func frameDigests(frames []types.Frame) []signature.Digest {
+	digests := make([]signature.Digest, len(frames))
+
+	for i, f := range frames {
+		digests[i] = signature.NewDigest(uint8(f.Kind), uint32(f.Sequence), f.Payload)
+	}
+
+	return digests
+}
+
+// signedVideo returns the frames of a video and its signature frames signed by key
+func signedVideo(t *testing.T, key signature.PrivateKey) ([]types.Frame, map[int]types.Frame) {
+	t.Helper()
+
+	frames := []types.Frame{
+		{Kind: types.KindData, Sequence: 0, Payload: []byte("first data frame")},
+		{Kind: types.KindData, Sequence: 1, Payload: []byte("second data frame")},
+		{Kind: types.KindData, Sequence: 2, Payload: []byte("third data frame")},
+		{Kind: types.KindParity, Sequence: 0, Payload: []byte("parity frame")},
+	}
+
+	payload, err := signature.Sign(key, frameDigests(frames)).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return frames, map[int]types.Frame{
+		0: {Kind: types.KindSignature, Payload: payload, TotalFrames: 1, TotalSize: uint64(len(payload))},
+	}
+}
+
+func TestCheckFrames(t *testing.T) {
+	var (
+		key                     = testSigningKey(t)
+		frames, signatureFrames = signedVideo(t, key)
+	)
+
+	tests := []struct {
+		name string
+
+		// frames decoded from the video, then rebuilt from parity frames
+		received, recovered []types.Frame
+
+		mismatch bool
+
+		// signed frames not found
+		missing int
+	}{
+		{"all frames", frames, nil, false, 0},
+		{"payload changed after signing", []types.Frame{
+			frames[0], {Kind: types.KindData, Sequence: 1, Payload: []byte("second data frame!")}, frames[2], frames[3],
+		}, nil, true, 1},
+		{"frames reordered", []types.Frame{
+			frames[0], {Kind: types.KindData, Sequence: 1, Payload: frames[2].Payload},
+			{Kind: types.KindData, Sequence: 2, Payload: frames[1].Payload}, frames[3],
+		}, nil, true, 2},
+		{"frame dropped", []types.Frame{frames[0], frames[2], frames[3]}, nil, false, 1},
+		{"parity frame changed", []types.Frame{
+			frames[0], frames[1], frames[2], {Kind: types.KindParity, Sequence: 0, Payload: []byte("other parity")},
+		}, nil, true, 1},
+		{"frame recovered", []types.Frame{frames[0], frames[2], frames[3]}, frames[1:2], false, 0},
+		{"wrong frame recovered", []types.Frame{frames[0], frames[2], frames[3]}, []types.Frame{
+			{Kind: types.KindData, Sequence: 1, Payload: []byte("rebuilt data frame")},
+		}, true, 1},
+	}
+
+	for _, tt := range tests {
+		for _, trusted := range []bool{false, true} {
+			var (
+				opts   types.DecodeOptions
+				report types.DecodeReport
+			)
+
+			if trusted {
+				opts.TrustedKeys = []signature.PublicKey{key.Public()}
+			}
+
+			digests, err := verifySignature(signatureFrames, opts, &report)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+
+			if err = checkFrames(digests, frameDigests(tt.received), opts, &report); err == nil && tt.recovered != nil {
+				err = checkFrames(digests, frameDigests(tt.recovered), opts, &report)
+			}
+
+			// mismatches fail the decoding with trusted keys, and are only reported without
+			switch {
+			case trusted && tt.mismatch && !errors.Is(err, signature.ErrFrameMismatch):
+				t.Fatalf("%s with trusted keys: got %v, expected %v", tt.name, err, signature.ErrFrameMismatch)
+			case trusted && !tt.mismatch && err != nil:
+				t.Fatalf("%s with trusted keys: %v", tt.name, err)
+			case !trusted && err != nil:
+				t.Fatalf("%s: %v", tt.name, err)
+			case !trusted && tt.mismatch != errors.Is(report.SignatureError, signature.ErrFrameMismatch):
+				t.Fatalf("%s: signature error %v", tt.name, report.SignatureError)
+			case (err == nil) && len(digests) != tt.missing:
+				t.Fatalf("%s: %d signed frames missing, expected %d", tt.name, len(digests), tt.missing)
+			}
+		}
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	var (
+		key                = testSigningKey(t)
+		other              = testSigningKey(t)
+		_, signatureFrames = signedVideo(t, key)
+		damaged            = map[int]types.Frame{
+			0: {Kind: types.KindSignature, Payload: []byte("{}"), TotalFrames: 1, TotalSize: 2},
+		}
+	)
+
+	tests := []struct {
+		name            string
+		signatureFrames map[int]types.Frame
+		trusted         []signature.PublicKey
+		fatal, warning  bool
+	}{
+		{"signed", signatureFrames, nil, false, false},
+		{"trusted signer", signatureFrames, []signature.PublicKey{other.Public(), key.Public()}, false, false},
+		{"untrusted signer", signatureFrames, []signature.PublicKey{other.Public()}, true, false},
+		{"unsigned", nil, nil, false, false},
+		{"unsigned with trusted keys", nil, []signature.PublicKey{key.Public()}, true, false},
+		{"damaged signature", damaged, nil, false, true},
+		{"damaged signature with trusted keys", damaged, []signature.PublicKey{key.Public()}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var report types.DecodeReport
+
+			digests, err := verifySignature(tt.signatureFrames, types.DecodeOptions{TrustedKeys: tt.trusted}, &report)
+
+			if (err != nil) != tt.fatal || (report.SignatureError != nil) != tt.warning {
+				t.Fatalf("got %v, signature error %v", err, report.SignatureError)
+			}
+
+			if err == nil && !tt.warning && (tt.signatureFrames == nil) != (digests == nil) {
+				t.Fatalf("%d signed digests", len(digests))
+			}
+
+			if err == nil && len(tt.trusted) > 0 && !report.Trusted {
+				t.Fatal("signer not trusted")
+			}
+		})
+	}
+}
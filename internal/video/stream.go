@@ -0,0 +1,418 @@
+package video
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/sabouaram/data2vid/internal/checksum"
+	"github.com/sabouaram/data2vid/internal/compress"
+	"github.com/sabouaram/data2vid/internal/encryption"
+	"github.com/sabouaram/data2vid/internal/fec"
+	"github.com/sabouaram/data2vid/internal/manifest"
+	"github.com/sabouaram/data2vid/internal/signature"
+	"github.com/sabouaram/data2vid/internal/types"
+)
+
+// errForeignFrame rejects data and parity frames that do not fit the data stream
+var errForeignFrame = errors.New("frame does not belong to the data stream")
+
+// dataStream stages the data stream of a video in a temporary file preallocated to its total size,
+// every data payload being written at its offset sequence*capacity as soon as it is decoded, so that
+// memory use does not grow with the video. Parity payloads are staged the same way in a second file
+// for the recovery of missing data frames.
+type dataStream struct {
+	dir         string
+	size        uint64
+	capacity    int
+	compression compress.Algorithm
+
+	data, parity, decoded *os.File
+
+	// data frames written, and the payload size of the parity frames written
+	received    map[int]bool
+	paritySizes map[int]int
+
+	// cross-frame erasure coding parameters, from the first parity frame
+	group types.Frame
+}
+
+// newDataStream creates the staging file of the data stream in dir, sized from the header of a data
+// or parity frame (nil frame for videos without data frames)
+func newDataStream(dir string, frame *types.Frame) (*dataStream, error) {
+	var err error
+
+	s := &dataStream{dir: dir, received: make(map[int]bool), paritySizes: make(map[int]int)}
+
+	if frame != nil {
+		s.size, s.capacity, s.compression = frame.TotalSize, frame.Capacity, frame.Compression
+
+		if s.capacity <= 0 {
+			return nil, fmt.Errorf("invalid frame capacity %d", s.capacity)
+		}
+	}
+
+	if s.data, err = os.CreateTemp(dir, ".data2vid-*.tmp"); err != nil {
+		return nil, fmt.Errorf("failed to create output: %w", err)
+	}
+
+	if err = s.data.Truncate(int64(s.size)); err != nil {
+		s.close()
+
+		return nil, fmt.Errorf("failed to allocate output: %w", err)
+	}
+
+	return s, nil
+}
+
+// stagingDir returns the directory the data stream is staged in: the output directory,
+// so that the restored file is renamed into place
+func stagingDir(outputPath string) string {
+	if outputPath == "" {
+		return "."
+	}
+
+	if stat, err := os.Stat(outputPath); err == nil && stat.IsDir() {
+		return outputPath
+	}
+
+	return filepath.Dir(outputPath)
+}
+
+// close removes the staging files
+func (s *dataStream) close() {
+	for _, file := range []*os.File{s.data, s.parity, s.decoded} {
+		if file != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}
+}
+
+// frames returns the number of data frames of the stream
+func (s *dataStream) frames() int {
+	if s.capacity == 0 {
+		return 0
+	}
+
+	return int((s.size + uint64(s.capacity) - 1) / uint64(s.capacity))
+}
+
+// offset returns the stream offset and the payload size of a data frame
+func (s *dataStream) offset(sequence int) (int64, int) {
+	offset := uint64(sequence) * uint64(s.capacity)
+
+	return int64(offset), int(min(uint64(s.capacity), s.size-offset))
+}
+
+// write stages the payload of a data or parity frame, payloads that do not fit the stream are rejected
+func (s *dataStream) write(frame types.Frame) error {
+	var err error
+
+	if frame.Sequence < 0 || frame.TotalSize != s.size || len(frame.Payload) > s.capacity {
+		return errForeignFrame
+	}
+
+	if frame.Kind == types.KindParity {
+		if s.parity == nil {
+			if s.parity, err = os.CreateTemp(s.dir, ".data2vid-*.tmp"); err != nil {
+				return fmt.Errorf("failed to create parity file: %w", err)
+			}
+
+			s.group = frame
+		}
+
+		if _, err = s.parity.WriteAt(frame.Payload, int64(frame.Sequence)*int64(s.capacity)); err != nil {
+			return fmt.Errorf("failed to write parity frame: %w", err)
+		}
+
+		s.paritySizes[frame.Sequence] = len(frame.Payload)
+
+		return nil
+	}
+
+	if frame.Sequence >= s.frames() {
+		return errForeignFrame
+	}
+
+	offset, size := s.offset(frame.Sequence)
+
+	if len(frame.Payload) != size {
+		return errForeignFrame
+	}
+
+	if _, err = s.data.WriteAt(frame.Payload, offset); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+
+	s.received[frame.Sequence] = true
+
+	return nil
+}
+
+// missing returns the number of data frames neither decoded nor recovered
+func (s *dataStream) missing() int {
+	return s.frames() - len(s.received)
+}
+
+// recover rebuilds the missing data frames using the surviving data and parity frames of their
+// group, and returns the digests of the rebuilt frames. Groups with more missing frames than parity
+// frames are skipped.
+func (s *dataStream) recover() ([]signature.Digest, error) {
+	var (
+		group     = s.group
+		recovered []signature.Digest
+		codec     *fec.Codec
+		err       error
+	)
+
+	if len(s.paritySizes) == 0 {
+		return nil, nil
+	}
+
+	if group.Capacity <= 0 || group.GroupSize <= 0 {
+		return nil, errors.New("invalid parity group parameters")
+	}
+
+	if codec, err = fec.NewCodec(group.ParityFrames); err != nil {
+		return nil, err
+	}
+
+	dataFrames := s.frames()
+
+	for start := 0; start < dataFrames; start += group.GroupSize {
+		var (
+			count   = min(group.GroupSize, dataFrames-start)
+			shards  = make([][]byte, count+group.ParityFrames)
+			size    = -1
+			missing []int
+		)
+
+		for j := 0; j < group.ParityFrames; j++ {
+			sequence := (start/group.GroupSize)*group.ParityFrames + j
+
+			if n, ok := s.paritySizes[sequence]; ok {
+				shards[count+j] = make([]byte, n)
+				size = n
+
+				if _, err = s.parity.ReadAt(shards[count+j], int64(sequence)*int64(s.capacity)); err != nil {
+					return nil, fmt.Errorf("failed to read parity frame: %w", err)
+				}
+			}
+		}
+
+		for i := 0; i < count; i++ {
+			if !s.received[start+i] {
+				missing = append(missing, i)
+			}
+		}
+
+		if len(missing) == 0 || size == -1 {
+			continue
+		}
+
+		// data shards are zero padded to the parity shard size
+		for i := 0; i < count; i++ {
+			if s.received[start+i] {
+				offset, n := s.offset(start + i)
+				shards[i] = make([]byte, size)
+
+				if _, err = s.data.ReadAt(shards[i][:min(n, size)], offset); err != nil {
+					return nil, fmt.Errorf("failed to read data frame: %w", err)
+				}
+			}
+		}
+
+		if err = codec.ReconstructShards(shards); err != nil {
+			continue
+		}
+
+		for _, i := range missing {
+			offset, n := s.offset(start + i)
+			payload := shards[i][:min(n, size)]
+
+			if _, err = s.data.WriteAt(payload, offset); err != nil {
+				return nil, fmt.Errorf("failed to write output: %w", err)
+			}
+
+			s.received[start+i] = true
+			recovered = append(recovered, signature.NewDigest(uint8(types.KindData), uint32(start+i), payload))
+		}
+	}
+
+	return recovered, nil
+}
+
+// restore returns the file holding the original data: the staged stream itself, or the stream
+// decrypted and decompressed into a second staging file. It is checked against the manifest, if any.
+// With a damage map (salvage), damaged chunks are zero-filled instead of failing the decryption,
+// decompression stops at the first damaged byte, and nothing is checked.
+func (s *dataStream) restore(key *encryption.Key, fileManifest *manifest.Manifest, damage *types.DamageMap) (*os.File, error) {
+	var (
+		reader io.Reader = io.NewSectionReader(s.data, 0, int64(s.size))
+		output           = &recordWriter{w: io.Discard}
+		file             = s.data
+		hash             = checksum.SHA256.New()
+		size   int64
+		err    error
+	)
+
+	if key != nil && damage != nil && s.compression == compress.None {
+		// decrypted chunks replace the stream ranges
+		damage.Ranges = nil
+
+		reader, err = key.NewSalvageReader(reader, s.size, "data", func(start, end uint64) {
+			damage.Ranges = addRange(damage.Ranges, start, end)
+		})
+	} else if key != nil {
+		reader, err = key.NewDecryptReader(reader, s.size, "data")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %w", err)
+	}
+
+	if fileManifest != nil {
+		digest, _ := fileManifest.Digest()
+		hash = digest.New()
+	}
+
+	// compressed streams can only be salvaged up to their first damaged byte
+	if damage != nil && s.compression != compress.None && len(damage.Ranges) > 0 {
+		limit := damage.Ranges[0].Start
+
+		if key != nil {
+			limit = limit / (encryption.ChunkSize + encryption.Overhead) * encryption.ChunkSize
+		}
+
+		reader = io.LimitReader(reader, int64(limit))
+	}
+
+	if s.compression != compress.None {
+		decompressor, err := s.compression.NewReader(reader)
+
+		switch {
+		case err != nil && damage != nil:
+			reader = errReader{err: err}
+		case err != nil:
+			return nil, fmt.Errorf("%s decompression failed: %w", s.compression, err)
+		default:
+			defer decompressor.Close()
+
+			reader = decompressor
+		}
+
+		// decompressed data going over the manifest size is refused as soon as it does, not once
+		// it filled the disk
+		if fileManifest != nil {
+			reader = io.LimitReader(reader, int64(min(fileManifest.Size, math.MaxInt64-1))+1)
+		}
+	}
+
+	// transformed streams are written out, plain ones only hashed
+	if key != nil || s.compression != compress.None {
+		if s.decoded, err = os.CreateTemp(s.dir, ".data2vid-*.tmp"); err != nil {
+			return nil, fmt.Errorf("failed to create output: %w", err)
+		}
+
+		file = s.decoded
+		output.w = file
+	} else if fileManifest == nil || damage != nil {
+		if damage != nil {
+			damage.Size = s.size
+		}
+
+		return file, nil
+	}
+
+	size, err = io.Copy(io.MultiWriter(output, hash), reader)
+
+	switch {
+	case output.err != nil:
+		return nil, fmt.Errorf("failed to write output: %w", output.err)
+	case errors.Is(err, encryption.ErrAuthentication):
+		return nil, fmt.Errorf("decryption failed: %w", err)
+	case s.compression != compress.None && fileManifest != nil && uint64(size) > fileManifest.Size:
+		return nil, fmt.Errorf("%s decompression failed: data exceeds the manifest size of %d bytes", s.compression, fileManifest.Size)
+	case damage != nil && s.compression != compress.None:
+		// cut at the first damaged byte: the rest of the file is lost
+		damage.Truncated = true
+		damage.Ranges = nil
+
+		if fileManifest != nil && fileManifest.Size > uint64(size) {
+			damage.Ranges = []types.ByteRange{{Start: uint64(size), End: fileManifest.Size}}
+		}
+	case err != nil:
+		return nil, fmt.Errorf("%s decompression failed: %w", s.compression, err)
+	}
+
+	if damage != nil {
+		damage.Size = uint64(size)
+
+		return file, nil
+	}
+
+	if fileManifest != nil {
+		if err = fileManifest.VerifySum(uint64(size), hash.Sum(nil)); err != nil {
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
+
+// damage returns the damage map of the stream: its missing data frames and the byte ranges they cover
+func (s *dataStream) damage() *types.DamageMap {
+	damage := &types.DamageMap{}
+
+	for sequence := range s.frames() {
+		if s.received[sequence] {
+			continue
+		}
+
+		offset, size := s.offset(sequence)
+
+		damage.MissingFrames = append(damage.MissingFrames, sequence)
+		damage.Ranges = addRange(damage.Ranges, uint64(offset), uint64(offset)+uint64(size))
+	}
+
+	return damage
+}
+
+// addRange appends a byte range, merged with the last one when they are contiguous
+func addRange(ranges []types.ByteRange, start, end uint64) []types.ByteRange {
+	if n := len(ranges); n > 0 && ranges[n-1].End == start {
+		ranges[n-1].End = end
+
+		return ranges
+	}
+
+	return append(ranges, types.ByteRange{Start: start, End: end})
+}
+
+// recordWriter records the error of its writer, so that write errors are told apart from read errors
+type recordWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+
+	return n, err
+}
+
+// errReader fails every read, it stands for a stream that cannot be decompressed at all
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
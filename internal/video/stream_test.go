@@ -0,0 +1,400 @@
+package video
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/sabouaram/data2vid/internal/checksum"
+	"github.com/sabouaram/data2vid/internal/compress"
+	"github.com/sabouaram/data2vid/internal/frame"
+	"github.com/sabouaram/data2vid/internal/manifest"
+	"github.com/sabouaram/data2vid/internal/types"
+)
+
+// encodeFrames renders the data and parity frames of data with the gray layout of the header
+// template and CRC-64 checksums, and decodes them back
+func encodeFrames(t *testing.T, header frame.Header, data []byte) []types.Frame {
+	t.Helper()
+
+	var (
+		rendered bytes.Buffer
+		sink     = &frame.Sink{Output: &rendered}
+		layout   = header.Layout
+		size     = layout.Width * layout.Height
+		frames   []types.Frame
+	)
+
+	header.TotalSize = uint64(len(data))
+	header.ChecksumAlgorithm = checksum.CRC64
+
+	if err := frame.CreateFrames(sink, bytes.NewReader(data), header); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range sink.Frames {
+		decoded, err := frame.ProcessFrame(frame.RawImage(rendered.Bytes()[i*size:(i+1)*size], layout.Width, layout.Height, "gray"))
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+
+		frames = append(frames, decoded)
+	}
+
+	return frames
+}
+
+func TestDataStreamRecover(t *testing.T) {
+	var (
+		layout = frame.Layout{Width: 160, Height: 120, BitsPerPixel: 1, BlockSize: 1, GroupSize: 5, ParityFrames: 2}
+		data   = make([]byte, 23*layout.PayloadSize()+500)
+	)
+
+	rand.NewChaCha8([32]byte{}).Read(data)
+
+	// 24 data frames in groups of 5 (the last one short, ending with a partial frame),
+	// each followed by 2 parity frames
+	frames := encodeFrames(t, frame.Header{Layout: layout}, data)
+
+	tests := []struct {
+		name     string
+		lostData []int // data frame sequences
+		lostPar  []int // parity frame sequences
+		missing  []int // data frames left missing
+	}{
+		{"nothing lost", nil, nil, nil},
+		{"one frame per group", []int{0, 6, 12, 18, 23}, nil, nil},
+		{"parity frames worth of frames", []int{1, 4, 5, 9}, nil, nil},
+		{"short last group", []int{20, 23}, nil, nil},
+		{"data and parity frames", []int{11}, []int{5}, nil},
+		{"too many losses", []int{10, 11, 12, 22}, nil, []int{10, 11, 12}},
+		{"lost parity frames", []int{15, 16}, []int{6, 7}, []int{15, 16}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newDataStream(t.TempDir(), &frames[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer s.close()
+
+			for _, f := range frames {
+				lost := tt.lostData
+
+				if f.Kind == types.KindParity {
+					lost = tt.lostPar
+				}
+
+				if slices.Contains(lost, f.Sequence) {
+					continue
+				}
+
+				if err = s.write(f); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			digests, err := s.recover()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if recovered := len(tt.lostData) - len(tt.missing); len(digests) != recovered {
+				t.Fatalf("%d frames recovered, expected %d", len(digests), recovered)
+			}
+
+			restored, err := io.ReadAll(io.NewSectionReader(s.data, 0, int64(s.size)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for sequence := range s.frames() {
+				var (
+					offset, size = s.offset(sequence)
+					missing      = slices.Contains(tt.missing, sequence)
+				)
+
+				if s.received[sequence] == missing {
+					t.Fatalf("frame %d received: %v", sequence, s.received[sequence])
+				}
+
+				if !missing && !bytes.Equal(restored[offset:offset+int64(size)], data[offset:offset+int64(size)]) {
+					t.Fatalf("frame %d not restored", sequence)
+				}
+			}
+		})
+	}
+}
+
+// payloads are written at their offsets whatever the order they are decoded in
+func TestDataStreamWrite(t *testing.T) {
+	var (
+		layout = frame.Layout{Width: 160, Height: 120, BitsPerPixel: 1, BlockSize: 1}
+		data   = make([]byte, 9*layout.PayloadSize()+100)
+		r      = rand.New(rand.NewPCG(1, 2))
+	)
+
+	rand.NewChaCha8([32]byte{}).Read(data)
+
+	frames := encodeFrames(t, frame.Header{Layout: layout}, data)
+
+	s, err := newDataStream(t.TempDir(), &frames[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.close()
+
+	// the last, partial frame first and every frame written twice
+	for _, i := range append([]int{len(frames) - 1}, r.Perm(len(frames))...) {
+		if err = s.write(frames[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	foreign := []types.Frame{
+		{Kind: types.KindData, Sequence: 10, TotalSize: uint64(len(data)), Payload: frames[9].Payload},
+		{Kind: types.KindData, Sequence: 3, TotalSize: uint64(len(data)), Payload: frames[9].Payload},
+		{Kind: types.KindData, Sequence: 3, TotalSize: uint64(len(data)) + 1, Payload: frames[3].Payload},
+		{Kind: types.KindData, Sequence: -1, TotalSize: uint64(len(data)), Payload: frames[3].Payload},
+		{Kind: types.KindData, Sequence: 0, TotalSize: uint64(len(data)), Payload: make([]byte, layout.PayloadSize()+1)},
+	}
+
+	for _, f := range foreign {
+		if err = s.write(f); !errors.Is(err, errForeignFrame) {
+			t.Fatalf("frame %d of %d bytes: got %v, expected %v", f.Sequence, len(f.Payload), err, errForeignFrame)
+		}
+	}
+
+	if s.missing() != 0 {
+		t.Fatalf("%d frames missing", s.missing())
+	}
+
+	output, err := s.restore(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restored, err := io.ReadAll(io.NewSectionReader(output, 0, int64(len(data)))); err != nil || !bytes.Equal(restored, data) {
+		t.Fatalf("data not restored: %v", err)
+	}
+}
+
+// readOutput returns the size first bytes of a restored file
+func readOutput(t *testing.T, output io.ReaderAt, size uint64) []byte {
+	t.Helper()
+
+	restored, err := io.ReadAll(io.NewSectionReader(output, 0, int64(size)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return restored
+}
+
+// frames lost beyond what parity can recover are zero-filled and reported in the damage map
+func TestDataStreamSalvage(t *testing.T) {
+	var (
+		layout = frame.Layout{Width: 160, Height: 120, BitsPerPixel: 1, BlockSize: 1, GroupSize: 4, ParityFrames: 1}
+		data   = make([]byte, 11*layout.PayloadSize()+300)
+		lost   = []int{1, 2, 4, 10, 11} // two frames of the first and last groups, one of the second
+	)
+
+	rand.NewChaCha8([32]byte{}).Read(data)
+
+	frames := encodeFrames(t, frame.Header{Layout: layout}, data)
+
+	s, err := newDataStream(t.TempDir(), &frames[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.close()
+
+	for _, f := range frames {
+		if f.Kind == types.KindData && slices.Contains(lost, f.Sequence) {
+			continue
+		}
+
+		if err = s.write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = s.recover(); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		damage   = s.damage()
+		capacity = uint64(layout.PayloadSize())
+		ranges   = []types.ByteRange{{Start: capacity, End: 3 * capacity}, {Start: 10 * capacity, End: uint64(len(data))}}
+	)
+
+	if !slices.Equal(damage.MissingFrames, []int{1, 2, 10, 11}) || !slices.Equal(damage.Ranges, ranges) {
+		t.Fatalf("damage map %+v", damage)
+	}
+
+	output, err := s.restore(nil, nil, damage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := bytes.Clone(data)
+
+	for _, r := range ranges {
+		clear(expected[r.Start:r.End])
+	}
+
+	if damage.Size != uint64(len(data)) || !bytes.Equal(readOutput(t, output, damage.Size), expected) {
+		t.Fatal("intact frames not restored or missing frames not zero-filled")
+	}
+}
+
+// compressed streams are restored up to their first damaged byte
+func TestDataStreamSalvageCompressed(t *testing.T) {
+	var (
+		layout     = frame.Layout{Width: 160, Height: 120, BitsPerPixel: 1, BlockSize: 1}
+		data       = make([]byte, 200000)
+		compressed bytes.Buffer
+	)
+
+	// compressible data
+	for i := range data {
+		data[i] = byte(i / 1000 * 7)
+	}
+
+	rand.NewChaCha8([32]byte{}).Read(data[:10000])
+
+	writer, err := compress.Gzip.NewWriter(&compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := encodeFrames(t, frame.Header{Layout: layout, Compression: compress.Gzip}, compressed.Bytes())
+
+	if len(frames) < 4 {
+		t.Fatalf("compressed stream in %d frames", len(frames))
+	}
+
+	s, err := newDataStream(t.TempDir(), &frames[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.close()
+
+	// the third frame is lost
+	for _, f := range slices.Delete(frames, 2, 3) {
+		if err = s.write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		fileManifest = &manifest.Manifest{Size: uint64(len(data))}
+		damage       = s.damage()
+	)
+
+	output, err := s.restore(nil, fileManifest, damage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the data compressed in the first two frames, at most
+	restored := readOutput(t, output, damage.Size)
+
+	if !damage.Truncated || damage.Size == 0 || !bytes.Equal(restored, data[:damage.Size]) {
+		t.Fatalf("%d bytes restored, truncated %v", damage.Size, damage.Truncated)
+	}
+
+	if !slices.Equal(damage.Ranges, []types.ByteRange{{Start: damage.Size, End: uint64(len(data))}}) {
+		t.Fatalf("damaged ranges %v", damage.Ranges)
+	}
+}
+
+// compressed streams are decompressed up to one byte over the manifest size, then refused
+func TestDataStreamDecompressionLimit(t *testing.T) {
+	var (
+		layout     = frame.Layout{Width: 160, Height: 120, BitsPerPixel: 1, BlockSize: 1}
+		data       = bytes.Repeat([]byte("data2vid "), 100000)
+		compressed bytes.Buffer
+	)
+
+	writer, err := compress.Zstd.NewWriter(&compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := encodeFrames(t, frame.Header{Layout: layout, Compression: compress.Zstd}, compressed.Bytes())
+
+	for _, tt := range []struct {
+		name string
+		size uint64
+		ok   bool
+	}{
+		{"manifest size", uint64(len(data)), true},
+		{"one byte short", uint64(len(data)) - 1, false},
+		{"empty", 0, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newDataStream(t.TempDir(), &frames[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer s.close()
+
+			for _, f := range frames {
+				if err = s.write(f); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			digest := checksum.SHA256.New()
+			digest.Write(data)
+
+			output, err := s.restore(nil, &manifest.Manifest{Size: tt.size, SHA256: hex.EncodeToString(digest.Sum(nil))}, nil)
+
+			switch {
+			case !tt.ok && err == nil:
+				t.Fatal("oversized data restored")
+			case !tt.ok:
+				info, statErr := s.decoded.Stat()
+				if statErr != nil {
+					t.Fatal(statErr)
+				}
+
+				if uint64(info.Size()) > tt.size+1 {
+					t.Fatalf("%d bytes decompressed for a %d bytes manifest", info.Size(), tt.size)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case !bytes.Equal(readOutput(t, output, tt.size), data):
+				t.Fatal("data not restored")
+			}
+		})
+	}
+}
@@ -4,223 +4,602 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"os"
+	"os/exec"
 	"path/filepath"
-	"sort"
-	"sync"
-	"time"
-
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sabouaram/data2vid/internal/archive"
+	"github.com/sabouaram/data2vid/internal/encryption"
+	"github.com/sabouaram/data2vid/internal/manifest"
+	"github.com/sabouaram/data2vid/internal/signature"
 	"github.com/sabouaram/data2vid/internal/types"
 	ffmpeg_go "github.com/u2takey/ffmpeg-go"
 )
 
-var fileMutex sync.Mutex
-
-// CreateVideo combines frames into an MP4 video file
-func CreateVideo(tempDir string, framePaths []string, outputVideo string) error {
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
+// Writer streams raw frames to an ffmpeg process encoding them into an MP4 video file,
+// no frame is stored on disk
+type Writer struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	output string
+}
 
+// NewWriter starts ffmpeg reading width x height raw frames of the inputFormat pixel format
+// (gray or rgb24) on its stdin, one frame per second of video at the given frame rate
+func NewWriter(outputVideo string, width, height, frameRate int, inputFormat, codec, pixelFormat string) (*Writer, error) {
 	var (
-		listFile, absPath, absLast, absOutput string
-		f                                     *os.File
-		err                                   error
+		absOutput string
+		err       error
+		writer    = &Writer{}
 	)
 
-	//  input file list for ffmpeg
-	listFile = filepath.Join(tempDir, "filelist.txt")
-
-	if f, err = os.Create(listFile); err != nil {
-		return fmt.Errorf("failed to create file list: %w", err)
-	}
-
-	defer f.Close()
-
-	// frames write
-	for _, path := range framePaths {
-		if absPath, err = filepath.Abs(path); err != nil {
-			return fmt.Errorf("failed to get absolute path: %w", err)
-		}
-
-		fmt.Fprintf(f, "file '%s'\n", absPath)
-		fmt.Fprintf(f, "duration 1\n") // Each frame lasts 1 second
-	}
-
-	// ffmpeg quirk
-	if len(framePaths) > 0 {
-		if absLast, err = filepath.Abs(framePaths[len(framePaths)-1]); err != nil {
-			return fmt.Errorf("failed to get absolute path: %w", err)
-		}
-
-		fmt.Fprintf(f, "file '%s'\n", absLast)
-	}
-
 	if absOutput, err = filepath.Abs(outputVideo); err != nil {
-		return fmt.Errorf("failed to get absolute output path: %w", err)
+		return nil, fmt.Errorf("failed to get absolute output path: %w", err)
 	}
 
 	//  ffmpeg command using ffmpeg-go - NB: only MP4
 	kwArgs := ffmpeg_go.KwArgs{
 		"y": "",
 	}
-	kwArgs["c:v"] = "libx264"
+	kwArgs["c:v"] = codec
 	kwArgs["preset"] = "ultrafast"
-	kwArgs["pix_fmt"] = "yuv420p"
+	kwArgs["pix_fmt"] = pixelFormat
 
-	stream := ffmpeg_go.Input(listFile, ffmpeg_go.KwArgs{
-		"f":    "concat",
-		"safe": "0",
+	stream := ffmpeg_go.Input("pipe:0", ffmpeg_go.KwArgs{
+		"f":         "rawvideo",
+		"pix_fmt":   inputFormat,
+		"s":         fmt.Sprintf("%dx%d", width, height),
+		"framerate": strconv.Itoa(frameRate),
 	}).Output(absOutput, kwArgs)
 
 	// lossless param
 	stream = stream.GlobalArgs("-qp", "0").
 		GlobalArgs("-x264-params", "qp=0")
 
-	if err = stream.Run(); err != nil {
+	writer.cmd = stream.Compile()
+	writer.output = absOutput
+
+	if writer.stdin, err = writer.cmd.StdinPipe(); err != nil {
+		return nil, fmt.Errorf("ffmpeg error: %w", err)
+	}
+
+	if err = writer.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("ffmpeg error: %w", err)
+	}
+
+	return writer, nil
+}
+
+// Write sends raw frame bytes to ffmpeg
+func (w *Writer) Write(p []byte) (int, error) {
+	n, err := w.stdin.Write(p)
+	if err != nil {
+		// ffmpeg exited: its status says why
+		if waitErr := w.cmd.Wait(); waitErr != nil {
+			err = waitErr
+		}
+
+		return n, fmt.Errorf("ffmpeg error: %w", err)
+	}
+
+	return n, nil
+}
+
+// Close ends the frame stream and waits for ffmpeg to finish the video
+func (w *Writer) Close() error {
+	w.stdin.Close()
+
+	if err := w.cmd.Wait(); err != nil {
 		return fmt.Errorf("ffmpeg error: %w", err)
 	}
 
 	return nil
 }
 
-// DecodeFile extracts and reconstructs the original file from MP4 video frames
-func DecodeFile(encoder types.FrameProcessor, videoPath, outputPath string) error {
+// Abort stops ffmpeg and removes the partial video
+func (w *Writer) Abort() {
+	w.stdin.Close()
+
+	if w.cmd.ProcessState == nil {
+		w.cmd.Process.Kill()
+		w.cmd.Wait()
+	}
+
+	os.Remove(w.output)
+}
+
+// DecodeFile extracts and reconstructs the original file from MP4 video frames. The file is
+// checked against the video manifest, whose file name is used when outputPath is empty.
+// Archive entries are extracted below outputPath, used as a directory.
+func DecodeFile(encoder types.FrameProcessor, videoPath, outputPath string, opts types.DecodeOptions) (types.DecodeReport, error) {
 	var (
-		tempDir, framePath, tempFile                   string
-		err                                            error
-		framePattern                                   string
-		fileSize, size                                 uint64
-		frames                                         []types.Frame
-		validFrames, frameCount, totalFrames, sequence int
-		seenSequences                                  = make(map[int]bool)
-		payload, reconstructed                         []byte
-		chunks                                         [][]byte
+		info            videoInfo
+		err             error
+		stream          *dataStream
+		streamErr       error
+		restored        *os.File
+		manifestFrames  = make(map[int]types.Frame)
+		tocFrames       = make(map[int]types.Frame)
+		keysFrames      = make(map[int]types.Frame)
+		signatureFrames = make(map[int]types.Frame)
+		digests         map[signature.Digest]bool
+		received        []signature.Digest
+		recovered       []signature.Digest
+		key             *encryption.Key
+		encrypted       bool
+		tocData         []byte
+		toc             archive.TOC
+		entries         []archive.Entry
+		manifestData    []byte
+		fileManifest    manifest.Manifest
+		report          types.DecodeReport
+		seenSequences   = make(map[int]bool)
+		seenParity      = make(map[int]bool)
+		restoreData     = !opts.MetadataOnly && !opts.VerifyOnly
+		metadataRead    atomic.Bool
 	)
 
-	if outputPath == "" {
-		return errors.New("output path cannot be empty")
+	// the data stream is staged next to the output so that it can be renamed into place
+	dir := stagingDir(outputPath)
+
+	if opts.Output != nil {
+		dir = os.TempDir()
 	}
 
-	// timestamped temp director //debugging
-	if tempDir, err = os.MkdirTemp("", fmt.Sprintf("ytdecode_%d_", time.Now().Unix())); err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
+	metadataStreams := map[types.FrameKind]map[int]types.Frame{
+		types.KindManifest:  manifestFrames,
+		types.KindTOC:       tocFrames,
+		types.KindKeys:      keysFrames,
+		types.KindSignature: signatureFrames,
 	}
 
-	defer func() error {
-		if err = os.RemoveAll(tempDir); err != nil {
-			return fmt.Errorf("failed to cleanup temp dir: %v", err)
+	// frames are self-describing: only the size and color channels to extract depend on the video
+	if info, err = probeVideo(videoPath); err != nil {
+		return report, err
+	}
+
+	// the staging files are removed unless renamed into place
+	defer func() {
+		if stream != nil {
+			stream.close()
 		}
-		return nil
 	}()
 
-	// extract frames
-	framePattern = filepath.Join(tempDir, "frame_%04d.png")
-	if err = ffmpeg_go.Input(videoPath).
-		Output(framePattern, ffmpeg_go.KwArgs{
-			"vsync":        "0",
-			"vf":           "fps=1",
-			"pix_fmt":      "gray",
-			"start_number": "0",
-		}).
-		Run(); err != nil {
-
-		// alternative
-		err = ffmpeg_go.Input(videoPath).
-			Output(framePattern, ffmpeg_go.KwArgs{
-				"vsync": "0",
-				"q:v":   "1",
-			}).
-			Run()
+	// frames decoded in parallel as they are streamed from ffmpeg (none is stored on disk),
+	// and collected in video order: data payloads are written to the output at their offset
+	// right away, only the metadata streams are kept in memory
+	decoder := newFrameDecoder(encoder, info, opts.Workers, func(frame types.Frame, err error) {
+		report.Frames++
+
+		if err != nil || streamErr != nil {
+			return
+		}
+
+		encrypted = encrypted || frame.Encrypted
+
+		// manifest and table of contents frames form their own streams
+		if metadata := metadataStreams[frame.Kind]; metadata != nil {
+			if _, ok := metadata[frame.Sequence]; !ok {
+				metadata[frame.Sequence] = frame
+
+				report.ValidFrames++
+				report.CorrectedSymbols += frame.Corrected
+			}
+
+			return
+		}
+
+		if frame.Kind != types.KindData && frame.Kind != types.KindParity {
+			return
+		}
+
+		// metadata frames come first: nothing else is read once they are all decoded
+		if opts.MetadataOnly {
+			metadataRead.Store(metadataComplete(keysFrames, manifestFrames, tocFrames))
 
+			return
+		}
+
+		// duplicated skip, parity frames are only needed to rebuild missing data frames
+		seen := seenSequences
+		if frame.Kind == types.KindParity {
+			seen = seenParity
+		}
+
+		if seen[frame.Sequence] {
+			return
+		}
+
+		// the first data or parity frame sizes the output
+		if stream == nil && restoreData {
+			if stream, streamErr = newDataStream(dir, &frame); streamErr != nil {
+				return
+			}
+		}
+
+		if stream != nil {
+			if err = stream.write(frame); errors.Is(err, errForeignFrame) {
+				return
+			} else if err != nil {
+				streamErr = err
+
+				return
+			}
+		}
+
+		seen[frame.Sequence] = true
+		received = append(received, signature.NewDigest(uint8(frame.Kind), uint32(frame.Sequence), frame.Payload))
+
+		report.ValidFrames++
+		report.CorrectedSymbols += frame.Corrected
+	})
+
+	err = extractFrames(videoPath, info, func(pixels []byte) bool {
+		decoder.decode(pixels)
+
+		return !metadataRead.Load()
+	})
+
+	decoder.close()
+
+	if streamErr != nil {
+		return report, streamErr
+	}
+
+	if report.Frames == 0 {
 		if err != nil {
-			return fmt.Errorf("frame extraction failed: %w", err)
+			return report, fmt.Errorf("frame extraction failed: %w", err)
 		}
+
+		return report, fmt.Errorf("no frames could be extracted from the video")
 	}
 
-	// extracted count
-	for i := 0; ; i++ {
-		framePath = filepath.Join(tempDir, fmt.Sprintf("frame_%04d.png", i))
+	if report.ValidFrames == 0 {
+		return report, fmt.Errorf("no valid frames found (attempted %d)", report.Frames)
+	}
 
-		if _, err = os.Stat(framePath); os.IsNotExist(err) {
-			break
+	// signed frames are checked before anything is decrypted or parsed
+	if digests, err = verifySignature(signatureFrames, opts, &report); err != nil {
+		return report, err
+	}
+
+	if digests != nil {
+		for _, metadata := range []map[int]types.Frame{keysFrames, manifestFrames, tocFrames} {
+			for frame := range maps.Values(metadata) {
+				received = append(received, signature.NewDigest(uint8(frame.Kind), uint32(frame.Sequence), frame.Payload))
+			}
+		}
+
+		if err = checkFrames(digests, received, opts, &report); err != nil {
+			return report, err
 		}
 
-		frameCount++
+		report.MissingFrames = len(digests)
 	}
 
-	if frameCount == 0 {
-		return fmt.Errorf("no frames could be extracted from the video")
+	if opts.VerifyOnly {
+		return report, nil
 	}
 
-	// process & storing frames
+	if len(keysFrames) > 0 {
+		if key, err = unlockKey(keysFrames, opts); err != nil {
+			return report, err
+		}
 
-	for frameIndex := 0; frameIndex < frameCount; frameIndex++ {
-		framePath = filepath.Join(tempDir, fmt.Sprintf("frame_%04d.png", frameIndex))
+		report.Encrypted = true
+	} else if encrypted {
+		return report, errors.New("the video is encrypted but its key frames were not found")
+	}
 
-		if _, err := os.Stat(framePath); os.IsNotExist(err) {
-			continue
+	// videos encoded before manifests were introduced have none
+	if len(manifestFrames) > 0 {
+		if manifestData, err = assembleStream(manifestFrames); err != nil {
+			return report, fmt.Errorf("manifest error: %w", err)
 		}
 
-		totalFrames++
+		if key != nil {
+			if manifestData, err = key.Open(manifestData, "manifest"); err != nil {
+				return report, fmt.Errorf("manifest decryption failed: %w", err)
+			}
+		}
 
-		if payload, size, sequence, err = encoder.ProcessFrameWithSequence(framePath); err != nil {
-			continue
+		if fileManifest, err = manifest.Parse(manifestData); err != nil {
+			return report, err
 		}
 
-		// duplicated skip
-		if seenSequences[sequence] {
-			continue
+		report.Manifest = &fileManifest
+	}
+
+	if len(tocFrames) > 0 {
+		if tocData, err = assembleStream(tocFrames); err != nil {
+			return report, fmt.Errorf("table of contents error: %w", err)
 		}
-		seenSequences[sequence] = true
 
-		if fileSize == 0 {
-			fileSize = size
+		if key != nil {
+			if tocData, err = key.Open(tocData, "toc"); err != nil {
+				return report, fmt.Errorf("table of contents decryption failed: %w", err)
+			}
+		}
 
+		if toc, err = archive.Parse(tocData); err != nil {
+			return report, err
 		}
 
-		frames = append(frames, types.Frame{
-			Sequence: sequence,
-			Payload:  payload,
-		})
+		report.TOC = &toc
+	}
 
-		validFrames++
+	if report.Manifest != nil && report.Manifest.Archive && report.TOC == nil {
+		return report, errors.New("archive table of contents not found")
 	}
 
-	if validFrames == 0 {
-		return fmt.Errorf("no valid frames found (attempted %d)", totalFrames)
+	if report.TOC != nil && opts.Output != nil {
+		return report, errors.New("archive videos are extracted to a directory, not written to a stream")
 	}
 
-	// sort frames by seq num
-	sort.Slice(frames, func(i, j int) bool {
-		return frames[i].Sequence < frames[j].Sequence
-	})
+	if report.TOC == nil && len(opts.Patterns) > 0 {
+		return report, errors.New("path patterns only apply to archive videos")
+	}
 
-	// extract payloads
-	for _, frame := range frames {
-		chunks = append(chunks, frame.Payload)
+	if opts.MetadataOnly {
+		return report, nil
 	}
 
-	// reconstruct original file data
-	reconstructed = bytes.Join(chunks, nil)
+	// empty files have no data frames
+	if stream == nil {
+		if stream, err = newDataStream(dir, nil); err != nil {
+			return report, err
+		}
+	}
 
-	if uint64(len(reconstructed)) > fileSize {
-		reconstructed = reconstructed[:fileSize]
-	} else if uint64(len(reconstructed)) < fileSize {
-		return fmt.Errorf("size mismatch: expected %d bytes, got %d", fileSize, len(reconstructed))
+	// rebuild missing data frames from the parity frames of their group
+	if recovered, err = stream.recover(); err != nil {
+		return report, fmt.Errorf("frame recovery failed: %w", err)
 	}
 
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
+	// recovered frames must be the signed ones too
+	if digests != nil && len(recovered) > 0 {
+		if err = checkFrames(digests, recovered, opts, &report); err != nil {
+			return report, err
+		}
+
+		report.MissingFrames = len(digests)
+	}
 
-	tempFile = outputPath + ".tmp"
+	report.RecoveredFrames = len(recovered)
 
-	if err = os.WriteFile(tempFile, reconstructed, 0644); err != nil {
-		return fmt.Errorf("failed to write output: %w", err)
+	// salvage: whatever is left, missing frames zero-filled
+	if missing := stream.missing(); missing > 0 && opts.Salvage {
+		report.Damage = stream.damage()
+	} else if missing > 0 {
+		return report, fmt.Errorf("incomplete data: %d of %d data frames missing", missing, stream.frames())
 	}
 
-	if err = os.Rename(tempFile, outputPath); err != nil {
-		return fmt.Errorf("failed to finalize output: %w", err)
+	// decrypted, decompressed and checked against the manifest
+	if restored, err = stream.restore(key, report.Manifest, report.Damage); err != nil {
+		return report, err
+	}
+
+	// archives: selected entries extracted below the output directory
+	if report.TOC != nil {
+		if outputPath == "" {
+			outputPath = "."
+		}
+
+		if entries, err = report.TOC.Select(opts.Patterns); err != nil {
+			return report, err
+		}
+
+		if report.Damage != nil {
+			if err = salvageArchive(restored, report.Damage, entries); err != nil {
+				return report, err
+			}
+		}
+
+		if err = archive.Extract(entries, restored, outputPath); err != nil {
+			return report, fmt.Errorf("extraction failed: %w", err)
+		}
+
+		report.OutputPath = outputPath
+		report.Extracted = len(entries)
+
+		return report, nil
+	}
+
+	if opts.Output != nil {
+		if _, err = restored.Seek(0, io.SeekStart); err != nil {
+			return report, fmt.Errorf("failed to read output: %w", err)
+		}
+
+		if _, err = io.Copy(opts.Output, restored); err != nil {
+			return report, fmt.Errorf("failed to write output: %w", err)
+		}
+
+		return report, nil
+	}
+
+	// no output or an output directory => original file name
+	if outputPath, err = restoredPath(videoPath, outputPath, report.Manifest, opts.Force); err != nil {
+		return report, err
+	}
+
+	report.OutputPath = outputPath
+
+	if err = restored.Chmod(0644); err != nil {
+		return report, fmt.Errorf("failed to finalize output: %w", err)
+	}
+
+	if err = restored.Close(); err != nil {
+		return report, fmt.Errorf("failed to write output: %w", err)
+	}
+
+	if err = os.Rename(restored.Name(), outputPath); err != nil {
+		return report, fmt.Errorf("failed to finalize output: %w", err)
+	}
+
+	// original permissions and modification time
+	if report.Manifest != nil {
+		if err = os.Chmod(outputPath, report.Manifest.Mode.Perm()); err != nil {
+			return report, fmt.Errorf("failed to restore file mode: %w", err)
+		}
+
+		if err = os.Chtimes(outputPath, report.Manifest.ModTime, report.Manifest.ModTime); err != nil {
+			return report, fmt.Errorf("failed to restore modification time: %w", err)
+		}
+	}
+
+	return report, nil
+}
+
+// salvageArchive lists the archive entries overlapping the damaged ranges, a truncated archive
+// is zero-filled up to its size so that every entry can still be extracted
+func salvageArchive(data *os.File, damage *types.DamageMap, entries []archive.Entry) error {
+	var size uint64
+
+	for _, entry := range entries {
+		size = max(size, entry.Offset+entry.Size)
+
+		for _, r := range damage.Ranges {
+			if entry.Type == archive.TypeFile && entry.Offset < r.End && entry.Offset+entry.Size > r.Start {
+				damage.DamagedEntries = append(damage.DamagedEntries, entry.Path)
+
+				break
+			}
+		}
+	}
+
+	if size > damage.Size {
+		if err := data.Truncate(int64(size)); err != nil {
+			return fmt.Errorf("failed to salvage archive: %w", err)
+		}
 	}
 
 	return nil
 }
+
+// restoredPath returns the path of the decoded file: outputPath, or the original file name when
+// outputPath is empty or a directory. An existing file is replaced when it is outputPath itself,
+// at the original file name only when force is set.
+func restoredPath(videoPath, outputPath string, fileManifest *manifest.Manifest, force bool) (string, error) {
+	if stat, err := os.Stat(outputPath); outputPath != "" && (err != nil || !stat.IsDir()) {
+		return outputPath, nil
+	}
+
+	path := filepath.Join(outputPath, defaultOutputPath(videoPath, fileManifest))
+
+	if _, err := os.Lstat(path); err == nil && !force {
+		return "", fmt.Errorf("%s already exists and is only replaced when forced", path)
+	}
+
+	return path, nil
+}
+
+// defaultOutputPath returns the file name recorded in the manifest, or [videoname]_decoded
+// for videos without a usable one
+func defaultOutputPath(videoPath string, fileManifest *manifest.Manifest) string {
+	if fileManifest != nil && fileManifest.SafeName() != "" {
+		return fileManifest.SafeName()
+	}
+
+	baseName := filepath.Base(videoPath)
+
+	return strings.TrimSuffix(baseName, filepath.Ext(baseName)) + "_decoded"
+}
+
+// unlockKey returns the file key of an encrypted video, unwrapped from the envelope of its
+// key frames with the passphrase
+func unlockKey(keysFrames map[int]types.Frame, opts types.DecodeOptions) (*encryption.Key, error) {
+	var (
+		data       []byte
+		envelope   encryption.Envelope
+		passphrase []byte
+		key        encryption.Key
+		err        error
+	)
+
+	if data, err = assembleStream(keysFrames); err != nil {
+		return nil, fmt.Errorf("encryption envelope error: %w", err)
+	}
+
+	if envelope, err = encryption.ParseEnvelope(data); err != nil {
+		return nil, err
+	}
+
+	if len(opts.Identities) > 0 {
+		if key, err = envelope.UnlockIdentities(opts.Identities); err == nil {
+			return &key, nil
+		}
+
+		// falls back on the passphrase when the video also has one
+		if !envelope.Has(encryption.StanzaScrypt) {
+			return nil, err
+		}
+	}
+
+	if !envelope.Has(encryption.StanzaScrypt) {
+		return nil, errors.New("the video is encrypted for recipients: an identity is required")
+	}
+
+	if opts.Passphrase == nil {
+		return nil, errors.New("the video is encrypted: a passphrase is required")
+	}
+
+	if passphrase, err = opts.Passphrase(); err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+
+	if key, err = envelope.UnlockPassphrase(passphrase); err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// metadataComplete reports whether every metadata stream found holds all of its frames
+func metadataComplete(streams ...map[int]types.Frame) bool {
+	for _, frames := range streams {
+		for _, frame := range frames {
+			if len(frames) < frame.TotalFrames {
+				return false
+			}
+
+			break
+		}
+	}
+
+	return true
+}
+
+// assembleStream concatenates the payloads of a metadata stream in sequence order,
+// all of its frames must have been decoded
+func assembleStream(frames map[int]types.Frame) ([]byte, error) {
+	var (
+		sample types.Frame
+		chunks [][]byte
+	)
+
+	for _, sample = range frames {
+		break
+	}
+
+	for sequence := 0; sequence < sample.TotalFrames; sequence++ {
+		frame, ok := frames[sequence]
+		if !ok {
+			return nil, fmt.Errorf("missing frame %d of %d", sequence, sample.TotalFrames)
+		}
+
+		chunks = append(chunks, frame.Payload)
+	}
+
+	data := bytes.Join(chunks, nil)
+
+	if uint64(len(data)) != sample.TotalSize {
+		return nil, fmt.Errorf("size mismatch: expected %d bytes, got %d", sample.TotalSize, len(data))
+	}
+
+	return data, nil
+}
@@ -0,0 +1,97 @@
+package video
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sabouaram/data2vid/internal/manifest"
+	"github.com/sabouaram/data2vid/internal/types"
+)
+
+// an existing file at the original file name is only replaced when forced, an explicit output
+// path always is
+func TestRestoredPath(t *testing.T) {
+	var (
+		dir          = t.TempDir()
+		existing     = filepath.Join(dir, "report.pdf")
+		fileManifest = &manifest.Manifest{Name: "report.pdf"}
+	)
+
+	if err := os.WriteFile(existing, []byte("kept"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		outputPath string
+		manifest   *manifest.Manifest
+		force      bool
+		want       string
+	}{
+		{"explicit existing file", existing, fileManifest, false, existing},
+		{"explicit new file", filepath.Join(dir, "out.pdf"), fileManifest, false, filepath.Join(dir, "out.pdf")},
+		{"directory, existing name", dir, fileManifest, false, ""},
+		{"directory, existing name forced", dir, fileManifest, true, existing},
+		{"directory, new name", dir, &manifest.Manifest{Name: "notes.txt"}, false, filepath.Join(dir, "notes.txt")},
+		{"directory, no manifest", dir, nil, false, filepath.Join(dir, "video_decoded")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := restoredPath("video.mp4", tt.outputPath, tt.manifest, tt.force)
+
+			switch {
+			case tt.want == "" && err == nil:
+				t.Fatalf("%s replaced", got)
+			case tt.want == "":
+			case err != nil:
+				t.Fatal(err)
+			case got != tt.want:
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// no output path: the original file name in the working directory
+	t.Chdir(dir)
+
+	if _, err := restoredPath("video.mp4", "", fileManifest, false); err == nil {
+		t.Fatal("existing file replaced without an output path")
+	}
+
+	if got, err := restoredPath("video.mp4", "", fileManifest, true); err != nil || got != "report.pdf" {
+		t.Fatalf("got %s, %v", got, err)
+	}
+}
+
+func TestMetadataComplete(t *testing.T) {
+	stream := func(total int, sequences ...int) map[int]types.Frame {
+		frames := make(map[int]types.Frame)
+
+		for _, sequence := range sequences {
+			frames[sequence] = types.Frame{Sequence: sequence, TotalFrames: total}
+		}
+
+		return frames
+	}
+
+	tests := []struct {
+		name    string
+		streams []map[int]types.Frame
+		want    bool
+	}{
+		{"no metadata", []map[int]types.Frame{stream(0), stream(0)}, true},
+		{"complete", []map[int]types.Frame{stream(2, 0, 1), stream(1, 0)}, true},
+		{"missing table of contents frame", []map[int]types.Frame{stream(1, 0), stream(3, 0, 2)}, false},
+		{"missing manifest frame", []map[int]types.Frame{stream(2, 1), stream(0)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := metadataComplete(tt.streams...); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -58,7 +58,7 @@ func init() {
 		Sampling:         nil,
 		Encoding:         "console",
 		EncoderConfig:    encoderConfig,
-		OutputPaths:      []string{"stdout"},
+		OutputPaths:      []string{"stderr"}, // stdout may carry data (decode -o -)
 		ErrorOutputPaths: []string{"stderr"},
 	}
 